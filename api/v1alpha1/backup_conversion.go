@@ -0,0 +1,425 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/copybird/copybird-crd/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// GenericModulesAnnotation lists v1beta1 generic modules that have a typed
+// representation, e.g. a generic mysql input. It keeps such modules generic
+// when v1beta1 object is converted to v1alpha1 and back.
+const GenericModulesAnnotation = "copybird.org/v1beta1-generic-modules"
+
+// Module slots listed in GenericModulesAnnotation
+const (
+	inputSlot    = "input"
+	outputSlot   = "output"
+	compressSlot = "compress"
+	encryptSlot  = "encrypt"
+)
+
+// scheduleOutputSlot is a GenericModulesAnnotation slot of the schedule output
+func scheduleOutputSlot(name string) string {
+	return fmt.Sprintf("schedules[%s].output", name)
+}
+
+var _ conversion.Convertible = &Backup{}
+
+// ConvertTo converts this Backup to the Hub version (v1beta1).
+// Modules are converted to the typed form if it represents them exactly,
+// otherwise they are kept as generic modules.
+func (src *Backup) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.Backup)
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	generic := sets.NewString()
+	if value, ok := dst.Annotations[GenericModulesAnnotation]; ok {
+		generic.Insert(strings.Split(value, ",")...)
+		delete(dst.Annotations, GenericModulesAnnotation)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	// fields other than modules have the same representation in both versions
+	dst.Spec = v1beta1.BackupSpec{}
+	if err := convertJSON(&src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+	dst.Spec.Input = convertInputTo(src.Spec.Input, generic.Has(inputSlot))
+	dst.Spec.Output = convertOutputTo(src.Spec.Output, generic.Has(outputSlot))
+	dst.Spec.Compress = convertCompressTo(src.Spec.Compress, generic.Has(compressSlot))
+	dst.Spec.Encrypt = convertEncryptTo(src.Spec.Encrypt, generic.Has(encryptSlot))
+	for i, schedule := range src.Spec.Schedules {
+		if schedule.Output != nil {
+			output := convertOutputTo(*schedule.Output, generic.Has(scheduleOutputSlot(schedule.Name)))
+			dst.Spec.Schedules[i].Output = &output
+		}
+	}
+
+	dst.Status = v1beta1.BackupStatus{}
+	return convertJSON(&src.Status, &dst.Status)
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *Backup) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.Backup)
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	dst.Spec = BackupSpec{}
+	if err := convertJSON(&src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+	dst.Spec.Input = convertInputFrom(src.Spec.Input)
+	dst.Spec.Output = convertOutputFrom(src.Spec.Output)
+	dst.Spec.Compress = convertCompressFrom(src.Spec.Compress)
+	dst.Spec.Encrypt = convertEncryptFrom(src.Spec.Encrypt)
+	for i, schedule := range src.Spec.Schedules {
+		if schedule.Output != nil {
+			output := convertOutputFrom(*schedule.Output)
+			dst.Spec.Schedules[i].Output = &output
+		}
+	}
+
+	var generic []string
+	if src.Spec.Input.Generic != nil && convertInputTo(dst.Spec.Input, false).Generic == nil {
+		generic = append(generic, inputSlot)
+	}
+	if src.Spec.Output.Generic != nil && convertOutputTo(dst.Spec.Output, false).Generic == nil {
+		generic = append(generic, outputSlot)
+	}
+	if src.Spec.Compress.Generic != nil && convertCompressTo(dst.Spec.Compress, false).Generic == nil {
+		generic = append(generic, compressSlot)
+	}
+	if src.Spec.Encrypt.Generic != nil && convertEncryptTo(dst.Spec.Encrypt, false).Generic == nil {
+		generic = append(generic, encryptSlot)
+	}
+	for i, schedule := range src.Spec.Schedules {
+		if schedule.Output != nil && schedule.Output.Generic != nil && convertOutputTo(*dst.Spec.Schedules[i].Output, false).Generic == nil {
+			generic = append(generic, scheduleOutputSlot(schedule.Name))
+		}
+	}
+	if len(generic) != 0 {
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[GenericModulesAnnotation] = strings.Join(generic, ",")
+	}
+
+	dst.Status = BackupStatus{}
+	return convertJSON(&src.Status, &dst.Status)
+}
+
+func convertJSON(src, dst interface{}) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+func convertInputTo(module Module, generic bool) v1beta1.InputModule {
+	if generic {
+		return v1beta1.InputModule{Generic: toGenericModule(module)}
+	}
+	if isEmptyModule(module) {
+		return v1beta1.InputModule{}
+	}
+	if database, ok := toDatabaseInput(module); ok {
+		switch module.Type {
+		case "mysql":
+			return v1beta1.InputModule{MySQL: database}
+		case "postgresql":
+			return v1beta1.InputModule{PostgreSQL: database}
+		case "mongodb":
+			return v1beta1.InputModule{MongoDB: database}
+		}
+	}
+	return v1beta1.InputModule{Generic: toGenericModule(module)}
+}
+
+func convertInputFrom(module v1beta1.InputModule) Module {
+	switch {
+	case module.MySQL != nil:
+		return fromDatabaseInput("mysql", module.MySQL)
+	case module.PostgreSQL != nil:
+		return fromDatabaseInput("postgresql", module.PostgreSQL)
+	case module.MongoDB != nil:
+		return fromDatabaseInput("mongodb", module.MongoDB)
+	}
+	return fromGenericModule(module.Generic)
+}
+
+func convertOutputTo(module Module, generic bool) v1beta1.OutputModule {
+	if generic {
+		return v1beta1.OutputModule{Generic: toGenericModule(module)}
+	}
+	if isEmptyModule(module) {
+		return v1beta1.OutputModule{}
+	}
+	switch module.Type {
+	case "s3":
+		if s3, ok := toS3Output(module); ok {
+			return v1beta1.OutputModule{S3: s3}
+		}
+	case "gcp":
+		if gcs, ok := toGCSOutput(module); ok {
+			return v1beta1.OutputModule{GCS: gcs}
+		}
+	}
+	return v1beta1.OutputModule{Generic: toGenericModule(module)}
+}
+
+func convertOutputFrom(module v1beta1.OutputModule) Module {
+	switch {
+	case module.S3 != nil:
+		return fromS3Output(module.S3)
+	case module.GCS != nil:
+		return fromGCSOutput(module.GCS)
+	}
+	return fromGenericModule(module.Generic)
+}
+
+func convertCompressTo(module Module, generic bool) v1beta1.CompressModule {
+	if generic {
+		return v1beta1.CompressModule{Generic: toGenericModule(module)}
+	}
+	if isEmptyModule(module) {
+		return v1beta1.CompressModule{}
+	}
+	if compression, ok := toCompression(module); ok {
+		switch module.Type {
+		case "gzip":
+			return v1beta1.CompressModule{Gzip: compression}
+		case "lz4":
+			return v1beta1.CompressModule{LZ4: compression}
+		}
+	}
+	return v1beta1.CompressModule{Generic: toGenericModule(module)}
+}
+
+func convertCompressFrom(module v1beta1.CompressModule) Module {
+	switch {
+	case module.Gzip != nil:
+		return fromCompression("gzip", module.Gzip)
+	case module.LZ4 != nil:
+		return fromCompression("lz4", module.LZ4)
+	}
+	return fromGenericModule(module.Generic)
+}
+
+func convertEncryptTo(module Module, generic bool) v1beta1.EncryptModule {
+	if generic {
+		return v1beta1.EncryptModule{Generic: toGenericModule(module)}
+	}
+	if isEmptyModule(module) {
+		return v1beta1.EncryptModule{}
+	}
+	if module.Type == "aesgcm" {
+		if aesgcm, ok := toAESGCMEncryption(module); ok {
+			return v1beta1.EncryptModule{AESGCM: aesgcm}
+		}
+	}
+	return v1beta1.EncryptModule{Generic: toGenericModule(module)}
+}
+
+func convertEncryptFrom(module v1beta1.EncryptModule) Module {
+	if module.AESGCM != nil {
+		return fromAESGCMEncryption(module.AESGCM)
+	}
+	return fromGenericModule(module.Generic)
+}
+
+// Typed modules are parsed leniently and then rendered back, the typed form
+// is used only if the rendered module is identical to the original one.
+
+func toDatabaseInput(module Module) (*v1beta1.DatabaseInput, bool) {
+	params, secrets := moduleValues(module)
+	database := &v1beta1.DatabaseInput{
+		DSN:          params["dsn"],
+		DSNSecretRef: secrets["dsn"],
+	}
+	return database, equality.Semantic.DeepEqual(fromDatabaseInput(module.Type, database), module)
+}
+
+func fromDatabaseInput(moduleType string, database *v1beta1.DatabaseInput) Module {
+	return newModuleBuilder(moduleType).
+		param("dsn", database.DSN).
+		secret("dsn", database.DSNSecretRef).
+		module
+}
+
+func toS3Output(module Module) (*v1beta1.S3Output, bool) {
+	params, secrets := moduleValues(module)
+	s3 := &v1beta1.S3Output{
+		Region:               params["region"],
+		Bucket:               params["bucket"],
+		FileName:             params["filename"],
+		CredentialsSecretRef: secrets["accesskeyid"],
+	}
+	return s3, equality.Semantic.DeepEqual(fromS3Output(s3), module)
+}
+
+func fromS3Output(s3 *v1beta1.S3Output) Module {
+	return newModuleBuilder("s3").
+		param("region", s3.Region).
+		param("bucket", s3.Bucket).
+		param("filename", s3.FileName).
+		secret("accesskeyid", s3.CredentialsSecretRef).
+		secret("secretaccesskey", s3.CredentialsSecretRef).
+		module
+}
+
+func toGCSOutput(module Module) (*v1beta1.GCSOutput, bool) {
+	params, _ := moduleValues(module)
+	gcs := &v1beta1.GCSOutput{
+		Bucket:   params["bucket"],
+		File:     params["file"],
+		AuthFile: params["authfile"],
+	}
+	return gcs, equality.Semantic.DeepEqual(fromGCSOutput(gcs), module)
+}
+
+func fromGCSOutput(gcs *v1beta1.GCSOutput) Module {
+	return newModuleBuilder("gcp").
+		param("bucket", gcs.Bucket).
+		param("file", gcs.File).
+		param("authfile", gcs.AuthFile).
+		module
+}
+
+func toCompression(module Module) (*v1beta1.Compression, bool) {
+	params, _ := moduleValues(module)
+	compression := &v1beta1.Compression{}
+	if value, ok := params["compressionlevel"]; ok {
+		level, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, false
+		}
+		compression.Level = new(int32)
+		*compression.Level = int32(level)
+	}
+	return compression, equality.Semantic.DeepEqual(fromCompression(module.Type, compression), module)
+}
+
+func fromCompression(moduleType string, compression *v1beta1.Compression) Module {
+	builder := newModuleBuilder(moduleType)
+	if compression.Level != nil {
+		builder.param("compressionlevel", strconv.Itoa(int(*compression.Level)))
+	}
+	return builder.module
+}
+
+func toAESGCMEncryption(module Module) (*v1beta1.AESGCMEncryption, bool) {
+	_, secrets := moduleValues(module)
+	aesgcm := &v1beta1.AESGCMEncryption{
+		KeySecretRef: secrets["encryptionkey"],
+	}
+	return aesgcm, equality.Semantic.DeepEqual(fromAESGCMEncryption(aesgcm), module)
+}
+
+func fromAESGCMEncryption(aesgcm *v1beta1.AESGCMEncryption) Module {
+	return newModuleBuilder("aesgcm").
+		secret("encryptionkey", aesgcm.KeySecretRef).
+		module
+}
+
+func toGenericModule(module Module) *v1beta1.GenericModule {
+	generic := &v1beta1.GenericModule{
+		Type: module.Type,
+	}
+	for _, param := range module.Params {
+		generic.Params = append(generic.Params, v1beta1.ModuleParam{Key: param.Key, Value: param.Value})
+	}
+	for _, secret := range module.Secrets {
+		generic.Secrets = append(generic.Secrets, v1beta1.ModuleSecret{SecretKeyRef: secret.SecretKeyRef.DeepCopy()})
+	}
+	return generic
+}
+
+func fromGenericModule(generic *v1beta1.GenericModule) Module {
+	module := Module{}
+	if generic == nil {
+		return module
+	}
+	module.Type = generic.Type
+	for _, param := range generic.Params {
+		module.Params = append(module.Params, ModuleParam{Key: param.Key, Value: param.Value})
+	}
+	for _, secret := range generic.Secrets {
+		module.Secrets = append(module.Secrets, ModuleSecret{SecretKeyRef: secret.SecretKeyRef.DeepCopy()})
+	}
+	return module
+}
+
+func isEmptyModule(module Module) bool {
+	return module.Type == "" && len(module.Params) == 0 && len(module.Secrets) == 0
+}
+
+// moduleValues returns module params and names of the secrets by key
+func moduleValues(module Module) (map[string]string, map[string]*corev1.LocalObjectReference) {
+	params := map[string]string{}
+	for _, param := range module.Params {
+		params[param.Key] = param.Value
+	}
+	secrets := map[string]*corev1.LocalObjectReference{}
+	for _, secret := range module.Secrets {
+		if secret.SecretKeyRef != nil {
+			secrets[secret.SecretKeyRef.Key] = secret.SecretKeyRef.LocalObjectReference.DeepCopy()
+		}
+	}
+	return params, secrets
+}
+
+// moduleBuilder renders typed module settings as module params and secrets
+type moduleBuilder struct {
+	module Module
+}
+
+func newModuleBuilder(moduleType string) *moduleBuilder {
+	return &moduleBuilder{module: Module{Type: moduleType}}
+}
+
+func (b *moduleBuilder) param(key, value string) *moduleBuilder {
+	if value != "" {
+		b.module.Params = append(b.module.Params, ModuleParam{Key: key, Value: value})
+	}
+	return b
+}
+
+func (b *moduleBuilder) secret(key string, ref *corev1.LocalObjectReference) *moduleBuilder {
+	if ref != nil {
+		b.module.Secrets = append(b.module.Secrets, ModuleSecret{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: *ref,
+				Key:                  key,
+			},
+		})
+	}
+	return b
+}
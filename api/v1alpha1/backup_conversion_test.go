@@ -0,0 +1,138 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/copybird/copybird-crd/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+func secretRef(name, key string) ModuleSecret {
+	return ModuleSecret{SecretKeyRef: &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: name},
+		Key:                  key,
+	}}
+}
+
+func TestBackupConversionRoundTrip(t *testing.T) {
+	keepLast := int32(3)
+	tests := map[string]BackupSpec{
+		"typed modules": {
+			Schedule: "0 3 * * *",
+			Input: Module{Type: "mysql", Params: []ModuleParam{
+				{Key: "dsn", Value: "root:root@tcp(mysql:3306)/foo"},
+			}},
+			Output: Module{Type: "s3",
+				Params: []ModuleParam{
+					{Key: "region", Value: "eu-central-1"},
+					{Key: "bucket", Value: "backups"},
+				},
+				Secrets: []ModuleSecret{secretRef("aws", "accesskeyid"), secretRef("aws", "secretaccesskey")},
+			},
+			Compress:  Module{Type: "gzip", Params: []ModuleParam{{Key: "compressionlevel", Value: "2"}}},
+			Encrypt:   Module{Type: "aesgcm", Secrets: []ModuleSecret{secretRef("copybird", "encryptionkey")}},
+			Retention: &RetentionPolicy{KeepLast: &keepLast},
+		},
+		"generic modules": {
+			Schedule: "@daily",
+			Input: Module{Type: "mysql", Params: []ModuleParam{
+				{Key: "host", Value: "mysql"},
+				{Key: "dsn", Value: "root:root@tcp(mysql:3306)/foo"},
+			}},
+			Output: Module{Type: "s3",
+				Secrets: []ModuleSecret{secretRef("aws", "accesskeyid"), secretRef("other", "secretaccesskey")},
+			},
+			Compress: Module{Type: "gzip", Params: []ModuleParam{{Key: "compressionlevel", Value: "fast"}}},
+			Encrypt:  Module{Type: "unknown", Params: []ModuleParam{{Key: "foo", Value: "bar"}}},
+		},
+		"schedules": {
+			Input: Module{Type: "mysql", Params: []ModuleParam{
+				{Key: "dsn", Value: "root:root@tcp(mysql:3306)/foo"},
+			}},
+			Output: Module{Type: "local", Params: []ModuleParam{{Key: "path", Value: "/backups"}}},
+			Schedules: []BackupSchedule{
+				{Name: "hourly", Schedule: "0 * * * *", Retention: &RetentionPolicy{KeepLast: &keepLast}},
+				{Name: "daily", Schedule: "0 3 * * *", Output: &Module{Type: "s3", Params: []ModuleParam{
+					{Key: "region", Value: "eu-central-1"},
+					{Key: "bucket", Value: "backups"},
+				}}},
+			},
+		},
+	}
+
+	for name, spec := range tests {
+		t.Run(name, func(t *testing.T) {
+			src := &Backup{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default"},
+				Spec:       spec,
+				Status:     BackupStatus{CronjobName: "default/backup", ConsecutiveFailures: 2},
+			}
+			hub := &v1beta1.Backup{}
+			if err := src.ConvertTo(hub); err != nil {
+				t.Fatalf("ConvertTo() error = %v", err)
+			}
+			if name == "typed modules" && (hub.Spec.Input.MySQL == nil || hub.Spec.Output.S3 == nil ||
+				hub.Spec.Compress.Gzip == nil || hub.Spec.Encrypt.AESGCM == nil) {
+				t.Errorf("modules are not converted to typed ones: %+v", hub.Spec)
+			}
+			if name == "schedules" && (hub.Spec.Schedules[0].Output != nil || hub.Spec.Schedules[1].Output.S3 == nil) {
+				t.Errorf("schedule outputs are not converted: %+v", hub.Spec.Schedules)
+			}
+			dst := &Backup{}
+			if err := dst.ConvertFrom(hub); err != nil {
+				t.Fatalf("ConvertFrom() error = %v", err)
+			}
+			if !equality.Semantic.DeepEqual(src, dst) {
+				t.Errorf("round trip changed the object:\n%s", diff.ObjectReflectDiff(src, dst))
+			}
+		})
+	}
+}
+
+func TestBackupConversionKeepsGenericModules(t *testing.T) {
+	hub := &v1beta1.Backup{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default"},
+		Spec: v1beta1.BackupSpec{
+			Schedule: "0 3 * * *",
+			Input: v1beta1.InputModule{Generic: &v1beta1.GenericModule{
+				Type:   "mysql",
+				Params: []v1beta1.ModuleParam{{Key: "dsn", Value: "root:root@tcp(mysql:3306)/foo"}},
+			}},
+			Compress: v1beta1.CompressModule{LZ4: &v1beta1.Compression{}},
+		},
+	}
+
+	spoke := &Backup{}
+	if err := spoke.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if got := spoke.Annotations[GenericModulesAnnotation]; got != inputSlot {
+		t.Errorf("%s annotation = %q, want %q", GenericModulesAnnotation, got, inputSlot)
+	}
+	dst := &v1beta1.Backup{}
+	if err := spoke.ConvertTo(dst); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	if !equality.Semantic.DeepEqual(hub, dst) {
+		t.Errorf("round trip changed the object:\n%s", diff.ObjectReflectDiff(hub, dst))
+	}
+}
@@ -0,0 +1,396 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/copybird/copybird-crd/pkg/schedule"
+	"github.com/robfig/cron/v3"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var backuplog = logf.Log.WithName("backup-resource")
+
+// Module types supported by copybird
+var (
+	inputModuleTypes    = sets.NewString("mysql", "postgresql", "mongodb", "etcd", "etcdv3", "consul", "local", "tar")
+	outputModuleTypes   = sets.NewString("s3", "gcp", "http", "local", "scp")
+	compressModuleTypes = sets.NewString("gzip", "lz4")
+	encryptModuleTypes  = sets.NewString("aesgcm")
+)
+
+// Backup spec defaults
+const (
+	DefaultCompressType               = "gzip"
+	DefaultJitter                     = time.Minute
+	DefaultSuccessfulJobsHistoryLimit = 3
+	DefaultFailedJobsHistoryLimit     = 1
+	DefaultConcurrencyPolicy          = batchv1beta1.ForbidConcurrent
+	DefaultRestartPolicy              = corev1.RestartPolicyOnFailure
+	DefaultExecutionMode              = ExecutionModeCronJob
+	DefaultCatchUpPolicy              = CatchUpPolicyRunOnce
+	DefaultDeletionPolicy             = DeletionPolicyRetain
+)
+
+// webhookReader reads BackupClasses while defaulting Backups
+var webhookReader client.Reader
+
+// Module env variable prefixes, see controllers/resources
+const (
+	inputEnvPrefix    = "COPYBIRD_INPUT"
+	outputEnvPrefix   = "COPYBIRD_OUTPUT"
+	compressEnvPrefix = "COPYBIRD_COMPRESS"
+	encryptEnvPrefix  = "COPYBIRD_ENCRYPT"
+)
+
+func (r *Backup) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	webhookReader = mgr.GetClient()
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-copybird-org-v1alpha1-backup,mutating=true,failurePolicy=fail,groups=copybird.org,resources=backups,verbs=create;update,versions=v1alpha1,name=mbackup.copybird.org
+
+var _ webhook.Defaulter = &Backup{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type.
+// Modules of the Backup class and the default compression are set in the
+// spec, so the stored object shows the effective configuration. The
+// controller resolves them again for Backups stored with webhooks disabled.
+// The image is left unset, so controller upgrades reach existing Backups.
+func (r *Backup) Default() {
+	backuplog.Info("default", "name", r.Name)
+
+	if webhookReader != nil {
+		class, err := GetBackupClass(context.Background(), webhookReader, r.Spec.BackupClassName)
+		if err != nil {
+			// the class is resolved again by the controller, which
+			// reports the error in the Backup status
+			backuplog.Info("can't get backup class", "name", r.Name, "reason", err)
+		} else if class != nil {
+			class.Spec.ApplyTo(&r.Spec)
+			r.Spec.BackupClassName = class.Name
+		}
+	}
+
+	if r.Spec.Compress.Type == "" {
+		r.Spec.Compress.Type = DefaultCompressType
+	}
+	if r.Spec.Jitter == nil {
+		r.Spec.Jitter = &metav1.Duration{Duration: DefaultJitter}
+	}
+	if r.Spec.SuccessfulJobsHistoryLimit == nil {
+		limit := int32(DefaultSuccessfulJobsHistoryLimit)
+		r.Spec.SuccessfulJobsHistoryLimit = &limit
+	}
+	if r.Spec.FailedJobsHistoryLimit == nil {
+		limit := int32(DefaultFailedJobsHistoryLimit)
+		r.Spec.FailedJobsHistoryLimit = &limit
+	}
+	if r.Spec.ConcurrencyPolicy == "" {
+		r.Spec.ConcurrencyPolicy = DefaultConcurrencyPolicy
+	}
+	if r.Spec.RestartPolicy == "" {
+		r.Spec.RestartPolicy = DefaultRestartPolicy
+	}
+	if r.Spec.ExecutionMode == "" {
+		r.Spec.ExecutionMode = DefaultExecutionMode
+	}
+	if r.Spec.ExecutionMode == ExecutionModeController && r.Spec.CatchUpPolicy == "" {
+		r.Spec.CatchUpPolicy = DefaultCatchUpPolicy
+	}
+	if r.Spec.DeletionPolicy == "" {
+		r.Spec.DeletionPolicy = DefaultDeletionPolicy
+	}
+	for i := range r.Spec.BlackoutWindows {
+		if r.Spec.BlackoutWindows[i].Policy == "" {
+			r.Spec.BlackoutWindows[i].Policy = BlackoutPolicySkip
+		}
+	}
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-copybird-org-v1alpha1-backup,mutating=false,failurePolicy=fail,groups=copybird.org,resources=backups,versions=v1alpha1,name=vbackup.copybird.org
+
+var _ webhook.Validator = &Backup{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Backup) ValidateCreate() error {
+	backuplog.Info("validate create", "name", r.Name)
+	return r.validateBackup()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+// Metadata changes like finalizer removal and spec-preserving updates are
+// admitted as is, so Backups stored before a validation rule was added can
+// still be annotated and deleted.
+func (r *Backup) ValidateUpdate(old runtime.Object) error {
+	backuplog.Info("validate update", "name", r.Name)
+	if r.DeletionTimestamp != nil {
+		return nil
+	}
+	if oldBackup, ok := old.(*Backup); ok && equality.Semantic.DeepEqual(r.Spec, oldBackup.Spec) {
+		return nil
+	}
+	return r.validateBackup()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Backup) ValidateDelete() error {
+	return nil
+}
+
+func (r *Backup) validateBackup() error {
+	allErrs := validateBackupSpec(&r.Spec, field.NewPath("spec"))
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "Backup"}, r.Name, allErrs)
+}
+
+func validateBackupSpec(spec *BackupSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	switch spec.ExecutionMode {
+	case "", ExecutionModeCronJob, ExecutionModeController:
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("executionMode"), spec.ExecutionMode,
+			[]string{string(ExecutionModeCronJob), string(ExecutionModeController)}))
+	}
+	switch spec.CatchUpPolicy {
+	case "", CatchUpPolicySkip, CatchUpPolicyRunOnce, CatchUpPolicyRunAll:
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("catchUpPolicy"), spec.CatchUpPolicy,
+			[]string{string(CatchUpPolicySkip), string(CatchUpPolicyRunOnce), string(CatchUpPolicyRunAll)}))
+	}
+	switch spec.DeletionPolicy {
+	case "", DeletionPolicyRetain, DeletionPolicyDelete:
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("deletionPolicy"), spec.DeletionPolicy,
+			[]string{string(DeletionPolicyRetain), string(DeletionPolicyDelete)}))
+	}
+
+	if spec.Schedule == "" && len(spec.Schedules) == 0 {
+		allErrs = append(allErrs, field.Required(path.Child("schedule"), "schedule or schedules must be set"))
+	} else if spec.Schedule != "" {
+		if err := validateSchedule(spec.Schedule, spec.ExecutionMode); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("schedule"), spec.Schedule, err.Error()))
+		}
+	}
+	scheduleNames := sets.NewString()
+	for i, schedule := range spec.Schedules {
+		schedulePath := path.Child("schedules").Index(i)
+		// schedule name is a suffix of the CronJob name
+		for _, msg := range validation.IsDNS1123Label(schedule.Name) {
+			allErrs = append(allErrs, field.Invalid(schedulePath.Child("name"), schedule.Name, msg))
+		}
+		if scheduleNames.Has(schedule.Name) {
+			allErrs = append(allErrs, field.Duplicate(schedulePath.Child("name"), schedule.Name))
+		}
+		scheduleNames.Insert(schedule.Name)
+		if schedule.Schedule == "" {
+			allErrs = append(allErrs, field.Required(schedulePath.Child("schedule"), "schedule must be set"))
+		} else if err := validateSchedule(schedule.Schedule, spec.ExecutionMode); err != nil {
+			allErrs = append(allErrs, field.Invalid(schedulePath.Child("schedule"), schedule.Schedule, err.Error()))
+		}
+		if schedule.Output != nil {
+			allErrs = append(allErrs, validateModule(schedule.Output, outputModuleTypes, outputEnvPrefix, schedulePath.Child("output"))...)
+		}
+	}
+	windowNames := sets.NewString()
+	for i, window := range spec.BlackoutWindows {
+		windowPath := path.Child("blackoutWindows").Index(i)
+		if window.Name == "" {
+			allErrs = append(allErrs, field.Required(windowPath.Child("name"), "window name must be set"))
+		} else if windowNames.Has(window.Name) {
+			allErrs = append(allErrs, field.Duplicate(windowPath.Child("name"), window.Name))
+		}
+		windowNames.Insert(window.Name)
+		if window.Start == "" {
+			allErrs = append(allErrs, field.Required(windowPath.Child("start"), "window start must be set"))
+		} else if _, err := cron.ParseStandard(window.Start); err != nil {
+			allErrs = append(allErrs, field.Invalid(windowPath.Child("start"), window.Start, err.Error()))
+		}
+		if window.Duration.Duration <= 0 {
+			allErrs = append(allErrs, field.Invalid(windowPath.Child("duration"), window.Duration.Duration.String(), "must be positive"))
+		}
+		switch window.Policy {
+		case "", BlackoutPolicySkip, BlackoutPolicyDefer:
+		default:
+			allErrs = append(allErrs, field.NotSupported(windowPath.Child("policy"), window.Policy,
+				[]string{string(BlackoutPolicySkip), string(BlackoutPolicyDefer)}))
+		}
+	}
+	if spec.TimeZone != "" {
+		if _, err := time.LoadLocation(spec.TimeZone); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("timeZone"), spec.TimeZone, err.Error()))
+		}
+	}
+
+	if spec.Input.Type == "" {
+		allErrs = append(allErrs, field.Required(path.Child("input", "type"), "input module type must be set"))
+	}
+	// output, compress and encrypt may come from the storage location or the BackupClass
+	allErrs = append(allErrs, validateModule(&spec.Input, inputModuleTypes, inputEnvPrefix, path.Child("input"))...)
+	allErrs = append(allErrs, validateModule(&spec.Output, outputModuleTypes, outputEnvPrefix, path.Child("output"))...)
+	allErrs = append(allErrs, validateModule(&spec.Compress, compressModuleTypes, compressEnvPrefix, path.Child("compress"))...)
+	allErrs = append(allErrs, validateModule(&spec.Encrypt, encryptModuleTypes, encryptEnvPrefix, path.Child("encrypt"))...)
+
+	if spec.Jitter != nil && spec.Jitter.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("jitter"), spec.Jitter.Duration.String(), "must not be negative"))
+	}
+	if spec.MaxAge != nil && spec.MaxAge.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("maxAge"), spec.MaxAge.Duration.String(), "must be positive"))
+	}
+	if limit := spec.SuccessfulJobsHistoryLimit; limit != nil && *limit < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("successfulJobsHistoryLimit"), *limit, "must not be negative"))
+	}
+	if limit := spec.FailedJobsHistoryLimit; limit != nil && *limit < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("failedJobsHistoryLimit"), *limit, "must not be negative"))
+	}
+	if policy := spec.ConcurrencyPolicy; policy != "" {
+		switch policy {
+		case batchv1beta1.AllowConcurrent, batchv1beta1.ForbidConcurrent, batchv1beta1.ReplaceConcurrent:
+		default:
+			allErrs = append(allErrs, field.NotSupported(path.Child("concurrencyPolicy"), policy,
+				[]string{string(batchv1beta1.AllowConcurrent), string(batchv1beta1.ForbidConcurrent), string(batchv1beta1.ReplaceConcurrent)}))
+		}
+	}
+	if policy := spec.RestartPolicy; policy != "" {
+		// Jobs don't support the Always restart policy
+		switch policy {
+		case corev1.RestartPolicyOnFailure, corev1.RestartPolicyNever:
+		default:
+			allErrs = append(allErrs, field.NotSupported(path.Child("restartPolicy"), policy,
+				[]string{string(corev1.RestartPolicyOnFailure), string(corev1.RestartPolicyNever)}))
+		}
+	}
+	if deadline := spec.StartingDeadlineSeconds; deadline != nil && *deadline < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("startingDeadlineSeconds"), *deadline, "must not be negative"))
+	}
+	if limit := spec.BackoffLimit; limit != nil && *limit < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("backoffLimit"), *limit, "must not be negative"))
+	}
+	if deadline := spec.ActiveDeadlineSeconds; deadline != nil && *deadline <= 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("activeDeadlineSeconds"), *deadline, "must be positive"))
+	}
+	if ttl := spec.TTLSecondsAfterFinished; ttl != nil && *ttl < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("ttlSecondsAfterFinished"), *ttl, "must not be negative"))
+	}
+
+	if template := spec.PodTemplate; template != nil {
+		// containers are merged by name onto the generated pod template
+		for i, container := range template.Spec.Containers {
+			if container.Name == "" {
+				allErrs = append(allErrs, field.Required(path.Child("podTemplate", "spec", "containers").Index(i).Child("name"),
+					"container name must be set, use \"copybird\" to customize the copybird container"))
+			}
+		}
+	}
+
+	if ref := spec.StorageLocation; ref != nil {
+		switch ref.Kind {
+		case "", BackupStorageLocationKind, ClusterBackupStorageLocationKind:
+		default:
+			allErrs = append(allErrs, field.NotSupported(path.Child("storageLocation", "kind"), ref.Kind,
+				[]string{BackupStorageLocationKind, ClusterBackupStorageLocationKind}))
+		}
+		if ref.Name == "" {
+			allErrs = append(allErrs, field.Required(path.Child("storageLocation", "name"), "storage location name must be set"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateSchedule checks the schedule with H tokens expanded. Whether
+// the expanded schedule is valid doesn't depend on the hash key.
+func validateSchedule(spec string, mode ExecutionMode) error {
+	expanded, err := schedule.Hash(spec, "")
+	if err != nil {
+		return err
+	}
+	parsed, err := cron.ParseStandard(expanded)
+	if err != nil {
+		return err
+	}
+	// CronJobs can't run more often than once a minute
+	if interval, ok := parsed.(cron.ConstantDelaySchedule); ok && interval.Delay < time.Minute && mode != ExecutionModeController {
+		return fmt.Errorf("intervals shorter than a minute require %s execution mode", ExecutionModeController)
+	}
+	return nil
+}
+
+// validateModule checks module type against the known ones and makes sure
+// params and secrets can be passed to copybird as env variables
+func validateModule(module *Module, types sets.String, envPrefix string, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if module.Type != "" && !types.Has(module.Type) {
+		allErrs = append(allErrs, field.NotSupported(path.Child("type"), module.Type, types.List()))
+	}
+
+	for i, param := range module.Params {
+		keyPath := path.Child("params").Index(i).Child("key")
+		allErrs = append(allErrs, validateEnvKey(param.Key, envPrefix, keyPath)...)
+	}
+
+	for i, secret := range module.Secrets {
+		refPath := path.Child("secrets").Index(i).Child("secretKeyRef")
+		if secret.SecretKeyRef == nil {
+			allErrs = append(allErrs, field.Required(refPath, "secretKeyRef must be set"))
+			continue
+		}
+		if secret.SecretKeyRef.Name == "" {
+			allErrs = append(allErrs, field.Required(refPath.Child("name"), "secret name must be set"))
+		}
+		allErrs = append(allErrs, validateEnvKey(secret.SecretKeyRef.Key, envPrefix, refPath.Child("key"))...)
+	}
+
+	return allErrs
+}
+
+// validateEnvKey checks that the key produces a valid env variable name
+func validateEnvKey(key, envPrefix string, path *field.Path) field.ErrorList {
+	if key == "" {
+		return field.ErrorList{field.Required(path, "key must be set")}
+	}
+	name := fmt.Sprintf("%s_%s", envPrefix, strings.ToUpper(key))
+	var allErrs field.ErrorList
+	for _, msg := range validation.IsEnvVarName(name) {
+		allErrs = append(allErrs, field.Invalid(path, key, fmt.Sprintf("env variable %s: %s", name, msg)))
+	}
+	return allErrs
+}
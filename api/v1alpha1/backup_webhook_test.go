@@ -0,0 +1,262 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func validSpec() BackupSpec {
+	return BackupSpec{
+		Schedule: "0 3 * * *",
+		Input: Module{Type: "mysql", Params: []ModuleParam{
+			{Key: "dsn", Value: "root:root@tcp(mysql:3306)/foo"},
+		}},
+		Output: Module{Type: "s3",
+			Params:  []ModuleParam{{Key: "bucket", Value: "backups"}},
+			Secrets: []ModuleSecret{secretRef("aws", "accesskeyid")},
+		},
+	}
+}
+
+func TestValidateBackupSpec(t *testing.T) {
+	int64Ptr := func(v int64) *int64 { return &v }
+	int32Ptr := func(v int32) *int32 { return &v }
+
+	tests := map[string]struct {
+		mutate func(spec *BackupSpec)
+		// fields are paths of the expected errors, none for a valid spec
+		fields []string
+	}{
+		"valid": {
+			mutate: func(spec *BackupSpec) {},
+		},
+		"schedule missing": {
+			mutate: func(spec *BackupSpec) { spec.Schedule = "" },
+			fields: []string{"spec.schedule"},
+		},
+		"additional schedules only": {
+			mutate: func(spec *BackupSpec) {
+				spec.Schedule = ""
+				spec.Schedules = []BackupSchedule{{Name: "daily", Schedule: "0 3 * * *"}}
+			},
+		},
+		"invalid schedule": {
+			mutate: func(spec *BackupSpec) { spec.Schedule = "0 25 * * *" },
+			fields: []string{"spec.schedule"},
+		},
+		"hashed schedule": {
+			mutate: func(spec *BackupSpec) { spec.Schedule = "H H(1-4) * * *" },
+		},
+		"sub-minute interval with CronJobs": {
+			mutate: func(spec *BackupSpec) { spec.Schedule = "@every 30s" },
+			fields: []string{"spec.schedule"},
+		},
+		"sub-minute interval with the controller": {
+			mutate: func(spec *BackupSpec) {
+				spec.Schedule = "@every 30s"
+				spec.ExecutionMode = ExecutionModeController
+			},
+		},
+		"invalid schedules": {
+			mutate: func(spec *BackupSpec) {
+				spec.Schedules = []BackupSchedule{
+					{Name: "Daily", Schedule: "0 3 * * *"},
+					{Name: "hourly", Schedule: ""},
+					{Name: "hourly", Schedule: "0 * * * *"},
+				}
+			},
+			fields: []string{"spec.schedules[0].name", "spec.schedules[1].schedule", "spec.schedules[2].name"},
+		},
+		"invalid blackout windows": {
+			mutate: func(spec *BackupSpec) {
+				spec.BlackoutWindows = []BlackoutWindow{
+					{Name: "", Start: "0 0 28 * *", Duration: metav1.Duration{Duration: time.Hour}},
+					{Name: "month-end", Start: "bad", Duration: metav1.Duration{}, Policy: "Later"},
+				}
+			},
+			fields: []string{"spec.blackoutWindows[0].name", "spec.blackoutWindows[1].start",
+				"spec.blackoutWindows[1].duration", "spec.blackoutWindows[1].policy"},
+		},
+		"unknown time zone": {
+			mutate: func(spec *BackupSpec) { spec.TimeZone = "Mars/Olympus" },
+			fields: []string{"spec.timeZone"},
+		},
+		"unsupported modules": {
+			mutate: func(spec *BackupSpec) {
+				spec.Input.Type = ""
+				spec.Output.Type = "ftp"
+			},
+			fields: []string{"spec.input.type", "spec.output.type"},
+		},
+		"invalid module keys": {
+			mutate: func(spec *BackupSpec) {
+				spec.Input.Params = append(spec.Input.Params, ModuleParam{Key: "bad key", Value: "x"})
+				spec.Output.Secrets = append(spec.Output.Secrets, ModuleSecret{})
+			},
+			fields: []string{"spec.input.params[1].key", "spec.output.secrets[1].secretKeyRef"},
+		},
+		"invalid Job settings": {
+			mutate: func(spec *BackupSpec) {
+				spec.Jitter = &metav1.Duration{Duration: -time.Second}
+				spec.MaxAge = &metav1.Duration{}
+				spec.SuccessfulJobsHistoryLimit = int32Ptr(-1)
+				spec.FailedJobsHistoryLimit = int32Ptr(-1)
+				spec.ConcurrencyPolicy = batchv1beta1.ConcurrencyPolicy("Sometimes")
+				spec.RestartPolicy = corev1.RestartPolicyAlways
+				spec.StartingDeadlineSeconds = int64Ptr(-1)
+				spec.BackoffLimit = int32Ptr(-1)
+				spec.ActiveDeadlineSeconds = int64Ptr(0)
+				spec.TTLSecondsAfterFinished = int32Ptr(-1)
+			},
+			fields: []string{"spec.jitter", "spec.maxAge", "spec.successfulJobsHistoryLimit",
+				"spec.failedJobsHistoryLimit", "spec.concurrencyPolicy", "spec.restartPolicy",
+				"spec.startingDeadlineSeconds", "spec.backoffLimit", "spec.activeDeadlineSeconds",
+				"spec.ttlSecondsAfterFinished"},
+		},
+		"unsupported policies": {
+			mutate: func(spec *BackupSpec) {
+				spec.ExecutionMode = "Cron"
+				spec.CatchUpPolicy = "Never"
+				spec.DeletionPolicy = "Archive"
+			},
+			fields: []string{"spec.executionMode", "spec.catchUpPolicy", "spec.deletionPolicy"},
+		},
+		"unnamed pod template container": {
+			mutate: func(spec *BackupSpec) {
+				spec.PodTemplate = &corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Image: "sidecar"}},
+				}}
+			},
+			fields: []string{"spec.podTemplate.spec.containers[0].name"},
+		},
+		"invalid storage location": {
+			mutate: func(spec *BackupSpec) {
+				spec.StorageLocation = &StorageLocationReference{Kind: "Bucket"}
+			},
+			fields: []string{"spec.storageLocation.kind", "spec.storageLocation.name"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			spec := validSpec()
+			test.mutate(&spec)
+			errs := validateBackupSpec(&spec, field.NewPath("spec"))
+			got := map[string]bool{}
+			for _, err := range errs {
+				got[err.Field] = true
+			}
+			for _, want := range test.fields {
+				if !got[want] {
+					t.Errorf("no error for %s, got %v", want, errs)
+				}
+				delete(got, want)
+			}
+			for unexpected := range got {
+				t.Errorf("unexpected error for %s: %v", unexpected, errs)
+			}
+		})
+	}
+}
+
+func TestValidateUpdateAdmitsMetadataChanges(t *testing.T) {
+	invalid := validSpec()
+	invalid.Input.Type = "removed"
+	old := &Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default"}, Spec: invalid}
+
+	annotated := old.DeepCopy()
+	annotated.Annotations = map[string]string{RunNowAnnotation: "1"}
+	if err := annotated.ValidateUpdate(old); err != nil {
+		t.Errorf("annotation update of an unchanged spec is rejected: %v", err)
+	}
+
+	deleted := old.DeepCopy()
+	now := metav1.Now()
+	deleted.DeletionTimestamp = &now
+	deleted.Finalizers = nil
+	if err := deleted.ValidateUpdate(old); err != nil {
+		t.Errorf("finalizer removal of a deleted Backup is rejected: %v", err)
+	}
+
+	changed := old.DeepCopy()
+	changed.Spec.Schedule = "0 4 * * *"
+	if err := changed.ValidateUpdate(old); err == nil {
+		t.Errorf("spec change keeping an invalid module is admitted")
+	}
+}
+
+func TestDefault(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatalf("can't build scheme: %v", err)
+	}
+	class := &BackupClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "standard",
+			Annotations: map[string]string{DefaultBackupClassAnnotation: "true"},
+		},
+		Spec: BackupClassSpec{
+			Encrypt: Module{Type: "aesgcm"},
+			Output:  Module{Type: "s3", Params: []ModuleParam{{Key: "bucket", Value: "backups"}}},
+		},
+	}
+	webhookReader = fake.NewFakeClientWithScheme(scheme, class)
+	defer func() { webhookReader = nil }()
+
+	backup := &Backup{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default"},
+		Spec: BackupSpec{
+			Schedule: "0 3 * * *",
+			Input:    Module{Type: "mysql"},
+			Output:   Module{Params: []ModuleParam{{Key: "prefix", Value: "mysql"}}},
+		},
+	}
+	backup.Default()
+
+	if backup.Spec.BackupClassName != "standard" {
+		t.Errorf("default class isn't recorded: %q", backup.Spec.BackupClassName)
+	}
+	if backup.Spec.Encrypt.Type != "aesgcm" || backup.Spec.Output.Type != "s3" || len(backup.Spec.Output.Params) != 2 {
+		t.Errorf("class modules aren't merged: %+v", backup.Spec)
+	}
+	if backup.Spec.Compress.Type != DefaultCompressType {
+		t.Errorf("expected compress %q, got %q", DefaultCompressType, backup.Spec.Compress.Type)
+	}
+	if backup.Spec.Jitter == nil || backup.Spec.Jitter.Duration != DefaultJitter {
+		t.Errorf("expected jitter %v, got %v", DefaultJitter, backup.Spec.Jitter)
+	}
+	if backup.Spec.Image != "" {
+		t.Errorf("image is defaulted: %q", backup.Spec.Image)
+	}
+
+	defaulted := backup.DeepCopy()
+	defaulted.Default()
+	if !equality.Semantic.DeepEqual(defaulted, backup) {
+		t.Errorf("defaulting isn't idempotent: %s", diff.ObjectReflectDiff(backup, defaulted))
+	}
+}
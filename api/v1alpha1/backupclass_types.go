@@ -0,0 +1,122 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultBackupClassAnnotation marks a BackupClass used by Backups
+	// that don't set backupClassName, like the StorageClass one
+	DefaultBackupClassAnnotation = "backupclass.copybird.org/is-default-class"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Compress",type="string",JSONPath=".spec.compress.type"
+// +kubebuilder:printcolumn:name="Encrypt",type="string",JSONPath=".spec.encrypt.type"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// BackupClass is the Schema for the backupclasses API. It holds
+// organization-wide module defaults for Backups.
+type BackupClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BackupClassSpec `json:"spec,omitempty"`
+}
+
+// BackupClassSpec defines module defaults applied to Backups of the class.
+//
+// Modules are merged in the following order, later ones taking precedence:
+// class module, then Backup module. Backup module of a different type replaces
+// the class module completely, otherwise Backup params and secrets replace
+// the class ones with the same key. Class output and storage location are
+// used only if Backup doesn't refer to its own storage location.
+type BackupClassSpec struct {
+	Compress        Module                    `json:"compress,omitempty"`
+	Encrypt         Module                    `json:"encrypt,omitempty"`
+	Output          Module                    `json:"output,omitempty"`
+	StorageLocation *StorageLocationReference `json:"storageLocation,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BackupClassList contains a list of BackupClass
+type BackupClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BackupClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BackupClass{}, &BackupClassList{})
+}
+
+// IsDefault returns true if the class is annotated as the default one
+func (in *BackupClass) IsDefault() bool {
+	return in.Annotations[DefaultBackupClassAnnotation] == "true"
+}
+
+// ApplyTo merges class modules into the Backup spec, fields set in the spec
+// win. It is idempotent, so the controller applying the class again to the
+// spec it was merged into on admission doesn't change it.
+func (in *BackupClassSpec) ApplyTo(spec *BackupSpec) {
+	spec.Compress = in.Compress.Merge(spec.Compress)
+	spec.Encrypt = in.Encrypt.Merge(spec.Encrypt)
+	if spec.StorageLocation == nil {
+		spec.Output = in.Output.Merge(spec.Output)
+		if in.StorageLocation != nil {
+			spec.StorageLocation = in.StorageLocation.DeepCopy()
+		}
+	}
+}
+
+// GetBackupClass returns the BackupClass with the given name or, if name
+// is empty, the default one. If several classes are marked as default the
+// newest one wins. It returns nil if name is empty and there is no default
+// class.
+func GetBackupClass(ctx context.Context, c client.Reader, name string) (*BackupClass, error) {
+	if name != "" {
+		class := &BackupClass{}
+		if err := c.Get(ctx, types.NamespacedName{Name: name}, class); err != nil {
+			return nil, fmt.Errorf("can't get backup class %q: %v", name, err)
+		}
+		return class, nil
+	}
+
+	classes := &BackupClassList{}
+	if err := c.List(ctx, classes); err != nil {
+		return nil, fmt.Errorf("can't list backup classes: %v", err)
+	}
+	var defaultClass *BackupClass
+	for i, class := range classes.Items {
+		if !class.IsDefault() {
+			continue
+		}
+		if defaultClass == nil || defaultClass.CreationTimestamp.Before(&class.CreationTimestamp) {
+			defaultClass = &classes.Items[i]
+		}
+	}
+	return defaultClass, nil
+}
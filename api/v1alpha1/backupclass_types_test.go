@@ -0,0 +1,184 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestBackupClassApplyTo(t *testing.T) {
+	class := BackupClassSpec{
+		Compress: Module{Type: "gzip", Params: []ModuleParam{{Key: "level", Value: "6"}}},
+		Encrypt:  Module{Type: "aesgcm", Secrets: []ModuleSecret{secretRef("encryption", "key")}},
+		Output: Module{Type: "s3",
+			Params:  []ModuleParam{{Key: "bucket", Value: "backups"}, {Key: "region", Value: "eu-west-1"}},
+			Secrets: []ModuleSecret{secretRef("aws", "accesskeyid")},
+		},
+	}
+
+	tests := map[string]struct {
+		class    func(class *BackupClassSpec)
+		spec     BackupSpec
+		expected BackupSpec
+	}{
+		"empty spec": {
+			expected: BackupSpec{
+				Compress: class.Compress,
+				Encrypt:  class.Encrypt,
+				Output:   class.Output,
+			},
+		},
+		"params of the same module": {
+			spec: BackupSpec{
+				Compress: Module{Params: []ModuleParam{{Key: "level", Value: "9"}}},
+				Output:   Module{Type: "s3", Params: []ModuleParam{{Key: "prefix", Value: "mysql"}}},
+			},
+			expected: BackupSpec{
+				Compress: Module{Type: "gzip", Params: []ModuleParam{{Key: "level", Value: "9"}}},
+				Encrypt:  class.Encrypt,
+				Output: Module{Type: "s3",
+					Params: []ModuleParam{{Key: "bucket", Value: "backups"}, {Key: "region", Value: "eu-west-1"},
+						{Key: "prefix", Value: "mysql"}},
+					Secrets: []ModuleSecret{secretRef("aws", "accesskeyid")},
+				},
+			},
+		},
+		"module of a different type": {
+			spec: BackupSpec{
+				Output: Module{Type: "gcs", Params: []ModuleParam{{Key: "bucket", Value: "gcs-backups"}}},
+			},
+			expected: BackupSpec{
+				Compress: class.Compress,
+				Encrypt:  class.Encrypt,
+				Output:   Module{Type: "gcs", Params: []ModuleParam{{Key: "bucket", Value: "gcs-backups"}}},
+			},
+		},
+		"storage location of the class": {
+			class: func(class *BackupClassSpec) {
+				class.StorageLocation = &StorageLocationReference{Name: "shared"}
+			},
+			expected: BackupSpec{
+				Compress:        class.Compress,
+				Encrypt:         class.Encrypt,
+				Output:          class.Output,
+				StorageLocation: &StorageLocationReference{Name: "shared"},
+			},
+		},
+		"storage location of the backup": {
+			class: func(class *BackupClassSpec) {
+				class.StorageLocation = &StorageLocationReference{Name: "shared"}
+			},
+			spec: BackupSpec{StorageLocation: &StorageLocationReference{Name: "own"}},
+			expected: BackupSpec{
+				Compress:        class.Compress,
+				Encrypt:         class.Encrypt,
+				StorageLocation: &StorageLocationReference{Name: "own"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			spec := class.DeepCopy()
+			if test.class != nil {
+				test.class(spec)
+			}
+			applied := test.spec.DeepCopy()
+			spec.ApplyTo(applied)
+			if !equality.Semantic.DeepEqual(*applied, test.expected) {
+				t.Errorf("unexpected spec: %s", diff.ObjectReflectDiff(test.expected, *applied))
+			}
+			again := applied.DeepCopy()
+			spec.ApplyTo(again)
+			if !equality.Semantic.DeepEqual(again, applied) {
+				t.Errorf("applying the class isn't idempotent: %s", diff.ObjectReflectDiff(applied, again))
+			}
+		})
+	}
+}
+
+func TestGetBackupClass(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatalf("can't build scheme: %v", err)
+	}
+	now := time.Now()
+	class := func(name string, age time.Duration, isDefault bool) *BackupClass {
+		class := &BackupClass{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(now.Add(-age)),
+		}}
+		if isDefault {
+			class.Annotations = map[string]string{DefaultBackupClassAnnotation: "true"}
+		}
+		return class
+	}
+
+	tests := map[string]struct {
+		objects  []runtime.Object
+		name     string
+		expected string
+		err      bool
+	}{
+		"named class": {
+			objects:  []runtime.Object{class("standard", time.Hour, true), class("encrypted", time.Hour, false)},
+			name:     "encrypted",
+			expected: "encrypted",
+		},
+		"named class missing": {
+			objects: []runtime.Object{class("standard", time.Hour, true)},
+			name:    "encrypted",
+			err:     true,
+		},
+		"default class": {
+			objects:  []runtime.Object{class("standard", time.Hour, true), class("encrypted", time.Minute, false)},
+			expected: "standard",
+		},
+		"newest default class": {
+			objects:  []runtime.Object{class("standard", time.Hour, true), class("encrypted", time.Minute, true)},
+			expected: "encrypted",
+		},
+		"no default class": {
+			objects: []runtime.Object{class("encrypted", time.Hour, false)},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := fake.NewFakeClientWithScheme(scheme, test.objects...)
+			class, err := GetBackupClass(context.Background(), c, test.name)
+			if (err != nil) != test.err {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			got := ""
+			if class != nil {
+				got = class.Name
+			}
+			if got != test.expected {
+				t.Errorf("expected class %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
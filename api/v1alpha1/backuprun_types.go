@@ -0,0 +1,66 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Backup",type="string",JSONPath=".spec.backupName"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// BackupRun is the Schema for the backupruns API. It is a one-off
+// execution of a Backup outside of its schedule.
+type BackupRun struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BackupRunSpec   `json:"spec,omitempty"`
+	Status BackupRunStatus `json:"status,omitempty"`
+}
+
+// BackupRunSpec defines the desired state of BackupRun
+type BackupRunSpec struct {
+	// BackupName is a name of the Backup in the same namespace to run
+	BackupName string `json:"backupName,omitempty"`
+}
+
+// BackupRunStatus defines the observed state of BackupRun
+type BackupRunStatus struct {
+	Phase      RunPhase     `json:"phase,omitempty"`
+	JobName    string       `json:"jobName,omitempty"`
+	StartTime  *metav1.Time `json:"startTime,omitempty"`
+	FinishTime *metav1.Time `json:"finishTime,omitempty"`
+	// Reason is a human readable explanation of a failure
+	Reason string `json:"reason,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BackupRunList contains a list of BackupRun
+type BackupRunList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BackupRun `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BackupRun{}, &BackupRunList{})
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// BackupStorageLocationKind is a kind of namespaced storage location
+	BackupStorageLocationKind = "BackupStorageLocation"
+	// ClusterBackupStorageLocationKind is a kind of cluster-scoped storage location
+	ClusterBackupStorageLocationKind = "ClusterBackupStorageLocation"
+
+	// ConditionAvailable indicates that storage location is valid and may be used by backups
+	ConditionAvailable = "Available"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Type",type="string",JSONPath=".spec.output.type"
+// +kubebuilder:printcolumn:name="Available",type="string",JSONPath=".status.conditions[?(@.type==\"Available\")].status"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// BackupStorageLocation is the Schema for the backupstoragelocations API.
+// It captures an output module shared by backups in its namespace.
+type BackupStorageLocation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BackupStorageLocationSpec   `json:"spec,omitempty"`
+	Status BackupStorageLocationStatus `json:"status,omitempty"`
+}
+
+// BackupStorageLocationSpec defines the desired state of BackupStorageLocation
+type BackupStorageLocationSpec struct {
+	// Output is a copybird output module backup artifacts are stored with.
+	// Module secrets are always looked up in the Backup namespace.
+	Output Module `json:"output,omitempty"`
+}
+
+// BackupStorageLocationStatus defines the observed state of BackupStorageLocation
+type BackupStorageLocationStatus struct {
+	ObservedGeneration int64       `json:"observedGeneration,omitempty"`
+	Conditions         []Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BackupStorageLocationList contains a list of BackupStorageLocation
+type BackupStorageLocationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BackupStorageLocation `json:"items"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Type",type="string",JSONPath=".spec.output.type"
+// +kubebuilder:printcolumn:name="Available",type="string",JSONPath=".status.conditions[?(@.type==\"Available\")].status"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ClusterBackupStorageLocation is the Schema for the clusterbackupstoragelocations API.
+// It is a cluster-scoped variant of BackupStorageLocation usable from any namespace.
+type ClusterBackupStorageLocation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BackupStorageLocationSpec   `json:"spec,omitempty"`
+	Status BackupStorageLocationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterBackupStorageLocationList contains a list of ClusterBackupStorageLocation
+type ClusterBackupStorageLocationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterBackupStorageLocation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BackupStorageLocation{}, &BackupStorageLocationList{})
+	SchemeBuilder.Register(&ClusterBackupStorageLocation{}, &ClusterBackupStorageLocationList{})
+}
@@ -0,0 +1,382 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:storageversion
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Schedule",type="string",JSONPath=".spec.schedule"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Last Success",type="date",JSONPath=".status.lastSuccessfulTime"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// Backup is the Schema for the backups API
+type Backup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BackupSpec   `json:"spec,omitempty"`
+	Status BackupStatus `json:"status,omitempty"`
+}
+
+// BackupSpec defines the desired state of Backup
+type BackupSpec struct {
+	// BackupClassName is a name of the BackupClass providing module defaults.
+	// The default class is used if it is empty.
+	BackupClassName string `json:"backupClassName,omitempty"`
+
+	// Schedule is a cron schedule of backup runs. H may be used instead
+	// of a field value, e.g. "H H(1-4) * * *", to run at a time picked
+	// from the Backup name, spreading Backups sharing a schedule.
+	Schedule string `json:"schedule,omitempty"`
+	// Schedules are additional schedules of the Backup, each with its own
+	// output and retention, e.g. hourly backups kept locally and daily ones
+	// shipped off-site. Schedule may be omitted if schedules are set.
+	Schedules []BackupSchedule `json:"schedules,omitempty"`
+	// ExecutionMode selects what runs the schedules: CronJob (default)
+	// or Controller
+	ExecutionMode ExecutionMode `json:"executionMode,omitempty"`
+	// CatchUpPolicy defines how runs missed in the Controller execution mode,
+	// e.g. while the controller was down, are handled. Defaults to RunOnce.
+	CatchUpPolicy CatchUpPolicy `json:"catchUpPolicy,omitempty"`
+	// BlackoutWindows are recurring periods backups must not run in.
+	// Backup CronJobs are suspended while a window is open.
+	BlackoutWindows []BlackoutWindow `json:"blackoutWindows,omitempty"`
+	// TimeZone is a name of the time zone the schedule is interpreted in,
+	// e.g. "Europe/Berlin". Defaults to UTC.
+	TimeZone string `json:"timeZone,omitempty"`
+	// Suspend stops scheduling of new backup runs, runs already started
+	// are not affected. The Backup is also suspended while the controller
+	// is in maintenance mode.
+	Suspend  *bool          `json:"suspend,omitempty"`
+	Input    InputModule    `json:"input,omitempty"`
+	Output   OutputModule   `json:"output,omitempty"`
+	Encrypt  EncryptModule  `json:"encrypt,omitempty"`
+	Compress CompressModule `json:"compress,omitempty"`
+	// StorageLocation refers to a shared output module. Output, if set,
+	// is merged on top of the location module.
+	StorageLocation *StorageLocationReference `json:"storageLocation,omitempty"`
+	// Retention defines how long backup artifacts are kept in the output.
+	// Artifacts are kept forever if it is not set.
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+	// Image is the copybird image backup Jobs run.
+	// Defaults to the image configured in the controller.
+	Image string `json:"image,omitempty"`
+	// Jitter is the maximum random delay copybird waits for before
+	// starting the backup, to spread load of backups sharing a schedule.
+	// Defaults to 1m, set it to 0s to start backups right on schedule.
+	Jitter *metav1.Duration `json:"jitter,omitempty"`
+	// MaxAge is the longest time allowed since the latest successful backup,
+	// i.e. the recovery point objective. The Backup gets the Stale condition
+	// once it is exceeded.
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+	// SuccessfulJobsHistoryLimit is a number of successful finished Jobs to keep
+	SuccessfulJobsHistoryLimit *int32 `json:"successfulJobsHistoryLimit,omitempty"`
+	// FailedJobsHistoryLimit is a number of failed finished Jobs to keep
+	FailedJobsHistoryLimit *int32 `json:"failedJobsHistoryLimit,omitempty"`
+	// ConcurrencyPolicy specifies how to treat a backup run started while
+	// the previous one is still running. Defaults to Forbid, so a hung
+	// backup doesn't stack up overlapping runs against the same database.
+	ConcurrencyPolicy batchv1beta1.ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+	// StartingDeadlineSeconds is a deadline for starting a run that missed
+	// its scheduled time. Missed runs are counted as failed.
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+	// BackoffLimit is a number of retries before a backup run is failed
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+	// ActiveDeadlineSeconds limits duration of a backup run, after which
+	// its pods are terminated and the run is failed
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+	// TTLSecondsAfterFinished is a time finished backup Jobs are kept for.
+	// Requires TTLAfterFinished feature to be enabled in the cluster.
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+	// RestartPolicy of backup pods, either OnFailure (default) or Never
+	RestartPolicy corev1.RestartPolicy `json:"restartPolicy,omitempty"`
+	// PodTemplate is strategically merged onto the pod template of backup
+	// Jobs to set resources, node placement, service account and so on.
+	// The copybird container is customized by a container named "copybird".
+	PodTemplate *corev1.PodTemplateSpec `json:"podTemplate,omitempty"`
+	// DeletionPolicy defines what happens to backup artifacts once the
+	// Backup is deleted, either Retain (default) or Delete
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// BackupSchedule is an additional schedule of the Backup run by its own CronJob
+type BackupSchedule struct {
+	// Name identifies the schedule and is appended to its CronJob name
+	Name string `json:"name"`
+	// Schedule is a cron schedule of backup runs, H tokens are supported
+	Schedule string `json:"schedule"`
+	// Output, if set, is merged on top of the Backup output
+	Output *OutputModule `json:"output,omitempty"`
+	// Retention replaces the Backup retention policy for artifacts of
+	// the schedule. Backup retention policy is used if it is not set.
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+}
+
+// ExecutionMode selects what runs the Backup schedules
+type ExecutionMode string
+
+const (
+	// ExecutionModeCronJob runs each schedule by a CronJob
+	ExecutionModeCronJob ExecutionMode = "CronJob"
+	// ExecutionModeController makes the controller create backup Jobs on
+	// schedule itself. It supports sub-minute intervals like "@every 30s"
+	// and time zones without CronJob limitations.
+	ExecutionModeController ExecutionMode = "Controller"
+)
+
+// CatchUpPolicy defines how missed runs are handled in the Controller execution mode
+type CatchUpPolicy string
+
+const (
+	// CatchUpPolicySkip drops missed runs. The latest run is started only if
+	// it is late by less than the starting deadline, a minute by default.
+	CatchUpPolicySkip CatchUpPolicy = "Skip"
+	// CatchUpPolicyRunOnce starts only the latest of the missed runs
+	CatchUpPolicyRunOnce CatchUpPolicy = "RunOnce"
+	// CatchUpPolicyRunAll starts all missed runs
+	CatchUpPolicyRunAll CatchUpPolicy = "RunAll"
+)
+
+// BlackoutWindow is a recurring period backups must not run in
+type BlackoutWindow struct {
+	// Name identifies the window in the Backup status
+	Name string `json:"name"`
+	// Start is a cron schedule the window opens on, interpreted in the
+	// Backup time zone, e.g. "0 0 28 * *" for month-end processing
+	Start string `json:"start"`
+	// Duration is the time the window stays open for
+	Duration metav1.Duration `json:"duration"`
+	// Policy defines what happens to runs scheduled within the window
+	Policy BlackoutPolicy `json:"policy,omitempty"`
+}
+
+// BlackoutPolicy defines what happens to runs scheduled within a blackout window
+type BlackoutPolicy string
+
+const (
+	// BlackoutPolicySkip drops runs scheduled within the window
+	BlackoutPolicySkip BlackoutPolicy = "Skip"
+	// BlackoutPolicyDefer runs the latest of the runs scheduled within the
+	// window once it is closed, unless it misses the starting deadline
+	BlackoutPolicyDefer BlackoutPolicy = "Defer"
+)
+
+// DeletionPolicy defines what happens to backup artifacts on Backup deletion
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyRetain keeps backup artifacts in the outputs
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyDelete removes backup artifacts from the outputs with
+	// a cleanup Job, the Backup is deleted once the Job succeeds
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+)
+
+// RetentionPolicy defines which backup artifacts are kept in the output.
+// An artifact is kept if any of the rules keeps it, everything else is
+// pruned after each successful backup.
+type RetentionPolicy struct {
+	// KeepLast is a number of the most recent artifacts to keep
+	KeepLast *int32 `json:"keepLast,omitempty"`
+	// KeepFor keeps artifacts younger than the duration
+	KeepFor *metav1.Duration `json:"keepFor,omitempty"`
+	// KeepDaily keeps the latest artifact for each of the last N days
+	KeepDaily *int32 `json:"keepDaily,omitempty"`
+	// KeepWeekly keeps the latest artifact for each of the last N weeks
+	KeepWeekly *int32 `json:"keepWeekly,omitempty"`
+	// KeepMonthly keeps the latest artifact for each of the last N months
+	KeepMonthly *int32 `json:"keepMonthly,omitempty"`
+}
+
+// StorageLocationReference refers to a BackupStorageLocation in the Backup
+// namespace or to a ClusterBackupStorageLocation
+type StorageLocationReference struct {
+	// Kind is either BackupStorageLocation (default) or ClusterBackupStorageLocation
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name"`
+}
+
+// BackupPhase is a high-level summary of the Backup state
+type BackupPhase string
+
+// Condition contains details for one aspect of the current state of a resource.
+// It follows the shape of upstream metav1.Condition.
+type Condition struct {
+	// Type of condition in CamelCase
+	Type string `json:"type"`
+	// Status of the condition, one of True, False, Unknown
+	Status corev1.ConditionStatus `json:"status"`
+	// ObservedGeneration is the resource generation the condition was set based upon
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastTransitionTime is the last time the condition transitioned from one status to another
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a programmatic identifier of the last transition in CamelCase
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable message with details about the transition
+	Message string `json:"message,omitempty"`
+}
+
+// BackupStatus defines the observed state of Backup
+type BackupStatus struct {
+	Conditions          []Condition  `json:"conditions,omitempty"`
+	Phase               BackupPhase  `json:"phase,omitempty"`
+	ObservedGeneration  int64        `json:"observedGeneration,omitempty"`
+	LastSuccessfulTime  *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+	ConsecutiveFailures int32        `json:"consecutiveFailures,omitempty"`
+	NextScheduleTime    *metav1.Time `json:"nextScheduleTime,omitempty"`
+	// EffectiveSchedule is the schedule of the Backup with H tokens
+	// expanded. Schedules run by CronJobs are translated to UTC.
+	EffectiveSchedule string `json:"effectiveSchedule,omitempty"`
+	// LastScheduleTime is the latest time a backup was scheduled at
+	// in the Controller execution mode
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	CronjobName           string       `json:"cronjobName,omitempty"`
+	LatestBackupTimestamp string       `json:"latestBackupTimestamp,omitempty"`
+	Jobs                  []JobStatus  `json:"jobs,omitempty"`
+	Prune                 *PruneStatus `json:"prune,omitempty"`
+
+	// Schedules reports the additional schedules
+	Schedules []ScheduleStatus `json:"schedules,omitempty"`
+	// Blackout is the blackout window the Backup is in
+	Blackout *BlackoutStatus `json:"blackout,omitempty"`
+	// SkippedRuns lists the latest runs that weren't started
+	SkippedRuns []SkippedRun `json:"skippedRuns,omitempty"`
+	// RunNow is the latest run triggered with the run-now annotation
+	RunNow *RunNowStatus `json:"runNow,omitempty"`
+	// Cleanup is a status of the artifacts removal on Backup deletion
+	Cleanup *CleanupStatus `json:"cleanup,omitempty"`
+	// LatestArtifact is the location of the artifact written
+	// by the latest successful run
+	LatestArtifact string `json:"latestArtifact,omitempty"`
+}
+
+// CleanupStatus is a status of the cleanup Jobs removing backup artifacts
+// from the outputs of the deleted Backup
+type CleanupStatus struct {
+	Phase RunPhase `json:"phase,omitempty"`
+	// Jobs are names of the cleanup Jobs, one for each Backup output
+	Jobs []string `json:"jobs,omitempty"`
+	// Message describes why the cleanup failed
+	Message string `json:"message,omitempty"`
+	// Failures is the number of failed cleanup attempts
+	Failures int32 `json:"failures,omitempty"`
+	// NextRetryTime is the time the failed cleanup Jobs are started again at
+	NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+}
+
+// RunNowStatus is a run started on demand with the run-now annotation
+type RunNowStatus struct {
+	// Trigger is the annotation value the run was started for
+	Trigger     string      `json:"trigger"`
+	JobName     string      `json:"jobName"`
+	TriggerTime metav1.Time `json:"triggerTime"`
+}
+
+// PruneStatus is a status of the latest retention policy enforcement
+type PruneStatus struct {
+	JobName       string       `json:"jobName,omitempty"`
+	Phase         RunPhase     `json:"phase,omitempty"`
+	LastPruneTime *metav1.Time `json:"lastPruneTime,omitempty"`
+	// PrunedArtifacts is a list of artifacts removed by the latest prune
+	PrunedArtifacts []string `json:"prunedArtifacts,omitempty"`
+}
+
+// RunPhase is a lifecycle phase of a single copybird Job
+type RunPhase string
+
+// BlackoutStatus is a status of an open blackout window
+type BlackoutStatus struct {
+	Window string         `json:"window"`
+	Policy BlackoutPolicy `json:"policy,omitempty"`
+	Start  metav1.Time    `json:"start"`
+	End    metav1.Time    `json:"end"`
+}
+
+// SkippedRun is a scheduled run that wasn't started
+type SkippedRun struct {
+	// Schedule is a name of the additional schedule of the run
+	Schedule      string      `json:"schedule,omitempty"`
+	ScheduledTime metav1.Time `json:"scheduledTime"`
+	// Window is a name of the blackout window that skipped the run. Runs
+	// missed or skipped by the concurrency policy in the Controller
+	// execution mode have none.
+	Window string `json:"window,omitempty"`
+}
+
+// ScheduleStatus is a status of an additional Backup schedule
+type ScheduleStatus struct {
+	Name              string       `json:"name"`
+	CronjobName       string       `json:"cronjobName,omitempty"`
+	EffectiveSchedule string       `json:"effectiveSchedule,omitempty"`
+	NextScheduleTime  *metav1.Time `json:"nextScheduleTime,omitempty"`
+	LastScheduleTime  *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// LastSuccessfulTime is the finish time of the latest successful
+	// backup run of the schedule
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+}
+
+type JobStatus struct {
+	Name       string       `json:"name,omitempty"`
+	Success    bool         `json:"success"`
+	StartTime  *metav1.Time `json:"startTime,omitempty"`
+	FinishTime *metav1.Time `json:"finishTime,omitempty"`
+
+	// Schedule is a name of the additional schedule the Job was run by
+	Schedule string `json:"schedule,omitempty"`
+	// Result is the summary of the successful run written by copybird
+	Result *RunResult `json:"result,omitempty"`
+}
+
+// RunResult is a summary of a backup run copybird writes as JSON to the
+// termination message of the backup pod
+type RunResult struct {
+	// ArtifactURI is the location of the artifact in the output
+	ArtifactURI string `json:"artifactURI,omitempty"`
+	// BytesIn is the size of the backup data read from the input,
+	// it's not set if the run doesn't report it
+	BytesIn *int64 `json:"bytesIn,omitempty"`
+	// BytesOut is the size of the artifact written to the output,
+	// it's not set if the run doesn't report it
+	BytesOut *int64 `json:"bytesOut,omitempty"`
+	// Checksum is the checksum of the artifact
+	Checksum string `json:"checksum,omitempty"`
+	// Duration is the time the backup took inside the pod
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// ModuleVersions maps module names to versions the backup was made with
+	ModuleVersions map[string]string `json:"moduleVersions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BackupList contains a list of Backup
+type BackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Backup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Backup{}, &BackupList{})
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// InputModule is the source of the backup. At most one field is set.
+type InputModule struct {
+	MySQL      *DatabaseInput `json:"mysql,omitempty"`
+	PostgreSQL *DatabaseInput `json:"postgresql,omitempty"`
+	MongoDB    *DatabaseInput `json:"mongodb,omitempty"`
+	// Generic is a module of any type configured with raw params
+	Generic *GenericModule `json:"generic,omitempty"`
+}
+
+// OutputModule is the destination of the backup. At most one field is set.
+type OutputModule struct {
+	S3  *S3Output  `json:"s3,omitempty"`
+	GCS *GCSOutput `json:"gcs,omitempty"`
+	// Generic is a module of any type configured with raw params
+	Generic *GenericModule `json:"generic,omitempty"`
+}
+
+// CompressModule compresses the backup. At most one field is set.
+type CompressModule struct {
+	Gzip *Compression `json:"gzip,omitempty"`
+	LZ4  *Compression `json:"lz4,omitempty"`
+	// Generic is a module of any type configured with raw params
+	Generic *GenericModule `json:"generic,omitempty"`
+}
+
+// EncryptModule encrypts the backup. At most one field is set.
+type EncryptModule struct {
+	AESGCM *AESGCMEncryption `json:"aesgcm,omitempty"`
+	// Generic is a module of any type configured with raw params
+	Generic *GenericModule `json:"generic,omitempty"`
+}
+
+// DatabaseInput dumps a database
+type DatabaseInput struct {
+	// DSN is the database data source name
+	DSN string `json:"dsn,omitempty"`
+	// DSNSecretRef refers to a secret holding the DSN in the "dsn" key.
+	// It is used instead of DSN when the DSN contains credentials.
+	DSNSecretRef *corev1.LocalObjectReference `json:"dsnSecretRef,omitempty"`
+}
+
+// S3Output uploads the backup to an S3 bucket
+type S3Output struct {
+	Region   string `json:"region,omitempty"`
+	Bucket   string `json:"bucket,omitempty"`
+	FileName string `json:"fileName,omitempty"`
+	// CredentialsSecretRef refers to a secret holding AWS credentials
+	// in the "accesskeyid" and "secretaccesskey" keys
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
+}
+
+// GCSOutput uploads the backup to a Google Cloud Storage bucket
+type GCSOutput struct {
+	Bucket string `json:"bucket,omitempty"`
+	File   string `json:"file,omitempty"`
+	// AuthFile is a path to the service account key file
+	AuthFile string `json:"authFile,omitempty"`
+}
+
+// Compression configures a compression module
+type Compression struct {
+	// Level is the compression level
+	Level *int32 `json:"level,omitempty"`
+}
+
+// AESGCMEncryption encrypts the backup with AES-GCM
+type AESGCMEncryption struct {
+	// KeySecretRef refers to a secret holding the encryption key
+	// in the "encryptionkey" key
+	KeySecretRef *corev1.LocalObjectReference `json:"keySecretRef,omitempty"`
+}
+
+// GenericModule is a Copybird module configured with raw params and
+// secrets passed to it as env variables
+type GenericModule struct {
+	Type    string         `json:"type,omitempty"`
+	Params  []ModuleParam  `json:"params,omitempty"`
+	Secrets []ModuleSecret `json:"secrets,omitempty"`
+}
+
+// ModuleParam contains key-value module parameter
+type ModuleParam struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// ModuleSecret contains a secret used by module
+type ModuleSecret struct {
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
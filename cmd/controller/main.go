@@ -19,12 +19,17 @@ package main
 import (
 	"flag"
 	"os"
+	// Backup time zones don't depend on the zone database of the image
+	_ "time/tzdata"
 
 	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	backupv1beta1 "github.com/copybird/copybird-crd/api/v1beta1"
 	"github.com/copybird/copybird-crd/controllers"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -39,13 +44,19 @@ var (
 func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 	_ = backupv1alpha1.AddToScheme(scheme)
+	_ = backupv1beta1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var metricsAddr string
+	var enableWebhooks bool
+	var maintenanceConfigMap string
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", true, "Serve admission webhooks. Requires serving certificates.")
+	flag.StringVar(&maintenanceConfigMap, "maintenance-configmap", "",
+		"The namespace/name of the ConfigMap suspending all backups when its \"suspend\" key is \"true\".")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(func(o *zap.Options) {
@@ -64,23 +75,82 @@ func main() {
 		os.Exit(1)
 	}
 
+	var maintenanceKey types.NamespacedName
+	if maintenanceConfigMap != "" {
+		namespace, name, err := cache.SplitMetaNamespaceKey(maintenanceConfigMap)
+		if err != nil || namespace == "" {
+			setupLog.Error(err, "invalid maintenance ConfigMap, must be namespace/name", "configmap", maintenanceConfigMap)
+			os.Exit(1)
+		}
+		maintenanceKey = types.NamespacedName{Namespace: namespace, Name: name}
+	}
+
 	if err = (&controllers.BackupReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Backup"),
-		Scheme: mgr.GetScheme(),
+		Client:               mgr.GetClient(),
+		Log:                  ctrl.Log.WithName("controllers").WithName("Backup"),
+		Scheme:               mgr.GetScheme(),
+		MaintenanceConfigMap: maintenanceKey,
+		Recorder:             mgr.GetEventRecorderFor("backup-controller"),
+		APIReader:            mgr.GetAPIReader(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Backup")
 		os.Exit(1)
 	}
 
 	if err = (&controllers.JobReconciler{
+		Client:    mgr.GetClient(),
+		Log:       ctrl.Log.WithName("controllers").WithName("Pod"),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("job-controller"),
+		APIReader: mgr.GetAPIReader(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Pod")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.RestoreReconciler{
 		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Pod"),
+		Log:    ctrl.Log.WithName("controllers").WithName("Restore"),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Pod")
+		setupLog.Error(err, "unable to create controller", "controller", "Restore")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.BackupRunReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("BackupRun"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BackupRun")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.BackupStorageLocationReconciler{
+		Client:    mgr.GetClient(),
+		Log:       ctrl.Log.WithName("controllers").WithName("BackupStorageLocation"),
+		Scheme:    mgr.GetScheme(),
+		APIReader: mgr.GetAPIReader(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BackupStorageLocation")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.ClusterBackupStorageLocationReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("ClusterBackupStorageLocation"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterBackupStorageLocation")
 		os.Exit(1)
 	}
+
+	if enableWebhooks {
+		if err = (&backupv1alpha1.Backup{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Backup")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -20,25 +20,41 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
 	"github.com/copybird/copybird-crd/controllers/resources"
+	cronschedule "github.com/copybird/copybird-crd/pkg/schedule"
 	"github.com/go-logr/logr"
+	"github.com/robfig/cron/v3"
+	v1 "k8s.io/api/batch/v1"
 	"k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
 	finalizerName        = "copybird-backup-controller"
 	copybirdImageEnvVar  = "COPYBIRD_IMAGE"
 	copybirdDefaultImage = "copybird/copybird:latest"
+
+	// maintenanceSuspendKey is the maintenance ConfigMap key
+	// suspending all Backups when set to "true"
+	maintenanceSuspendKey = "suspend"
 )
 
 // BackupReconciler reconciles a Backup object
@@ -46,10 +62,33 @@ type BackupReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// MaintenanceConfigMap is the ConfigMap switching maintenance mode on and
+	// off. All Backup CronJobs are suspended while in maintenance. Maintenance
+	// mode is disabled if the name is empty.
+	MaintenanceConfigMap types.NamespacedName
+
+	// Recorder reports Backup lifecycle Events
+	Recorder record.EventRecorder
+
+	// APIReader reads Secrets referenced by modules directly from the API
+	// server, so the controller doesn't cache all Secrets of the cluster
+	APIReader client.Reader
+
+	// maintenanceReader reads the maintenance ConfigMap from the cache
+	// limited to its namespace
+	maintenanceReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=copybird.org,resources=backups,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=copybird.org,resources=backups/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=copybird.org,resources=backupstoragelocations;clusterbackupstoragelocations,verbs=get;list;watch
+// +kubebuilder:rbac:groups=copybird.org,resources=backupclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile implements controllbackup.Nameer reconcilation logic
 func (r *BackupReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
@@ -70,92 +109,697 @@ func (r *BackupReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return result, nil
 	}
 
-	accessor, err := meta.Accessor(backup)
-	if err != nil {
-		log.Error(err, "Failed to get metadata accessor")
+	if backup.GetDeletionTimestamp() != nil {
+		finalized, err := r.finalize(ctx, backup)
+		if err != nil {
+			result.Requeue = true
+			log.Info("reconcilation error", "reason", err)
+			return result, err
+		}
+		// metrics are kept while cleanup Jobs are running
+		if finalized {
+			forgetBackupMetrics(backup)
+		} else if cleanup := backup.Status.Cleanup; cleanup != nil && cleanup.NextRetryTime != nil {
+			// requeue to start failed cleanup Jobs again
+			requeueBefore(&result, cleanup.NextRetryTime.Time)
+		}
+		return result, nil
+	}
+
+	if err := r.addFinalizer(ctx, backup); err != nil {
+		result.Requeue = true
+		log.Info("failed to add finalizer", "reason", err)
 		return result, err
 	}
 
-	var reconcileErr error
-	if accessor.GetDeletionTimestamp() == nil {
-		reconcileErr = r.reconcile(ctx, backup)
-	} else {
-		reconcileErr = r.finalize(ctx, backup)
+	desired := backup.DeepCopy()
+	reconcileErr := r.reconcile(ctx, desired)
+	staleAt, fresh := r.reconcileStaleness(desired, time.Now())
+
+	// status is saved even if reconcilation failed, so the failure
+	// is reported in the Backup conditions
+	err := patchStatus(ctx, r.Client, backup, func() {
+		applyScheduleStatus(backup, &desired.Status)
+		updateBackupPhase(backup)
+	})
+	if err != nil {
+		result.Requeue = true
+		log.Info("failed to update object status", "reason", err)
+		return result, err
 	}
 
+	updateBackupMetrics(backup)
+
 	if reconcileErr != nil {
 		result.Requeue = true
 		log.Info("reconcilation error", "reason", reconcileErr)
-		return result, err
+		return result, reconcileErr
 	}
 
-	if err := r.Update(ctx, backup); err != nil {
-		result.Requeue = true
-		log.Info("failed to update object", "reason", err)
-		return result, err
+	// requeue to refresh next schedule time once the schedule fires
+	if next := backup.Status.NextScheduleTime; next != nil {
+		requeueBefore(&result, next.Time)
+	}
+	// requeue to translate the schedule again once the time zone offset changes
+	if backup.Spec.TimeZone != "" {
+		if loc, err := time.LoadLocation(backup.Spec.TimeZone); err == nil {
+			if transition, ok := cronschedule.NextTransition(loc, time.Now()); ok {
+				requeueBefore(&result, transition)
+			}
+		}
+	}
+	// requeue to mark the Backup stale once maxAge passes without a successful backup
+	if fresh {
+		requeueBefore(&result, staleAt)
+	}
+	// requeue to suspend and resume CronJobs once blackout windows open and close
+	if change, ok := nextBlackoutChange(backup, time.Now()); ok {
+		requeueBefore(&result, change)
 	}
+	// Secrets aren't watched, so the referenced ones are checked periodically
+	requeueBefore(&result, time.Now().Add(secretsResyncPeriod))
 
 	return result, nil
 }
 
+// requeueBefore makes the result requeue the request right after the time,
+// unless it is already requeued earlier
+func requeueBefore(result *ctrl.Result, t time.Time) {
+	after := time.Until(t) + time.Second
+	if after < time.Second {
+		after = time.Second
+	}
+	if result.RequeueAfter == 0 || after < result.RequeueAfter {
+		result.RequeueAfter = after
+	}
+}
+
+// reconcile brings the Backup CronJobs to the desired state and sets the
+// Backup status fields owned by BackupReconciler
 func (r *BackupReconciler) reconcile(ctx context.Context, backup *backupv1alpha1.Backup) error {
 	log := r.Log.WithName("reconciler")
-	if len(backup.GetFinalizers()) == 0 {
-		backup.Finalizers = []string{finalizerName}
+	backup.Status.ObservedGeneration = backup.Generation
+
+	resolved, err := resolveBackup(ctx, r.Client, backup)
+	if err != nil {
+		setBackupCondition(backup, backupv1alpha1.ConditionScheduled, corev1.ConditionFalse, "ResolveFailed", err.Error())
+		return err
 	}
 
-	copybirdImage, defined := os.LookupEnv(copybirdImageEnvVar)
-	if !defined {
-		log.Info("environment variable \"" + copybirdImageEnvVar + "\" not defined, using default value: \"" + copybirdDefaultImage)
-		copybirdImage = copybirdDefaultImage
+	if err := r.reconcileSecrets(ctx, backup, resolved); err != nil {
+		return err
+	}
+	r.reconcileDeletionPolicy(backup, resolved)
+
+	if err := r.reconcileRunNow(ctx, backup, resolved); err != nil {
+		return err
 	}
 
-	copybird := resources.NewCopyBirdParams(copybirdImage, backup)
-	cronjob := &v1beta1.CronJob{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      backup.Name,
-			Namespace: backup.Namespace,
-		},
+	now := time.Now()
+	blackout, err := activeBlackout(resolved, now)
+	if err != nil {
+		// invalid window can't be fixed by retrying
+		setBackupCondition(backup, backupv1alpha1.ConditionScheduled, corev1.ConditionFalse, "InvalidBlackoutWindow", err.Error())
+		backup.Status.NextScheduleTime = nil
+		return nil
 	}
 
-	backup.Status.CronjobName = fmt.Sprintf("%s/%s", cronjob.Namespace, cronjob.Name)
+	suspended, err := r.reconcileSuspend(ctx, backup, resolved, blackout)
+	if err != nil {
+		return err
+	}
 
-	err := r.Get(ctx, client.ObjectKey{Namespace: cronjob.Namespace, Name: cronjob.Name}, cronjob)
-	if apierrors.IsNotFound(err) {
-		cronjob = copybird.MakeCronJob(ctx)
-	} else if err != nil {
+	// the main schedule is optional if additional schedules are set
+	var names []string
+	if resolved.Spec.Schedule != "" {
+		names = append(names, "")
+	}
+	for _, schedule := range resolved.Spec.Schedules {
+		names = append(names, schedule.Name)
+	}
+
+	// runs of a closed Skip window must not be caught up by CronJobs
+	skipUntil := recordBlackout(backup, resolved, names, blackout, now)
+
+	var next *metav1.Time
+	var schedules []backupv1alpha1.ScheduleStatus
+	cronJobs := sets.NewString()
+	for _, name := range names {
+		status, err := r.reconcileSchedule(ctx, backup, scheduleBackup(resolved, name), name, suspended, skipUntil)
+		if err != nil {
+			scheduleErr := err.(*scheduleError)
+			setBackupCondition(backup, backupv1alpha1.ConditionScheduled, corev1.ConditionFalse, scheduleErr.reason, err.Error())
+			backup.Status.NextScheduleTime = nil
+			if scheduleErr.permanent {
+				return nil
+			}
+			return scheduleErr.err
+		}
+		if status.CronjobName != "" {
+			cronJobs.Insert(resources.MakeCronJobName(backup.Name, name))
+		}
+		if next == nil || (status.NextScheduleTime != nil && status.NextScheduleTime.Before(next)) {
+			next = status.NextScheduleTime
+		}
+		if name == "" {
+			backup.Status.CronjobName = status.CronjobName
+			backup.Status.EffectiveSchedule = status.EffectiveSchedule
+			backup.Status.LastScheduleTime = status.LastScheduleTime
+		} else {
+			schedules = append(schedules, status)
+		}
+	}
+	if resolved.Spec.Schedule == "" {
+		backup.Status.CronjobName = ""
+		backup.Status.EffectiveSchedule = ""
+		backup.Status.LastScheduleTime = nil
+	}
+	backup.Status.Schedules = schedules
+
+	if err := r.deleteStaleCronJobs(ctx, backup, cronJobs); err != nil {
+		setBackupCondition(backup, backupv1alpha1.ConditionScheduled, corev1.ConditionFalse, "CronJobFailed", err.Error())
 		return err
 	}
+	log.Info("Cronjobs successfully reconciled", "count", cronJobs.Len())
+
+	setBackupCondition(backup, backupv1alpha1.ConditionScheduled, corev1.ConditionTrue, "CronJobReconciled", "")
+	backup.Status.NextScheduleTime = next
+	// the window is forgotten only once CronJobs are resumed
+	backup.Status.Blackout = blackout
+	return nil
+}
+
+// scheduleError is a failure to reconcile a Backup schedule
+// reported in the Scheduled condition
+type scheduleError struct {
+	reason string
+	// permanent errors can't be fixed by retrying
+	permanent bool
+	err       error
+}
 
+func (e *scheduleError) Error() string {
+	return e.err.Error()
+}
+
+// reconcileSchedule brings the CronJob of the named Backup schedule to the
+// desired state or runs the schedule in the Controller execution mode. The
+// scheduled Backup is the resolved Backup as it is run by the schedule. Runs
+// missed before skipUntil aren't caught up once the schedule is resumed.
+func (r *BackupReconciler) reconcileSchedule(ctx context.Context, backup, scheduled *backupv1alpha1.Backup, name string, suspended bool, skipUntil *metav1.Time) (backupv1alpha1.ScheduleStatus, error) {
+	log := r.Log.WithName("reconciler")
+	status := backupv1alpha1.ScheduleStatus{Name: name}
+	fail := func(reason string, permanent bool, err error) (backupv1alpha1.ScheduleStatus, error) {
+		if name != "" {
+			err = fmt.Errorf("schedule %q: %v", name, err)
+		}
+		return status, &scheduleError{reason: reason, permanent: permanent, err: err}
+	}
+
+	spec, schedule, err := parseSchedule(scheduled, name)
+	if err != nil {
+		// invalid schedule can't be fixed by retrying
+		return fail("InvalidSchedule", true, err)
+	}
+	scheduled.Spec.Schedule = spec
+
+	if scheduled.Spec.ExecutionMode == backupv1alpha1.ExecutionModeController {
+		// the controller runs the schedule in its time zone itself
+		status.EffectiveSchedule = spec
+		copybird := resources.NewCopyBirdParams(backupImage(log, scheduled), scheduled)
+		copybird.Schedule = name
+		if _, err := copybird.MakeScheduledJob(ctx, time.Now()); err != nil {
+			// invalid pod template can't be fixed by retrying
+			return fail("InvalidPodTemplate", true, err)
+		}
+		status.LastScheduleTime, err = r.runSchedule(ctx, backup, copybird, schedule, suspended, skipUntil)
+		if err != nil {
+			return fail("JobFailed", false, err)
+		}
+		if !suspended {
+			next := metav1.NewTime(schedule.Next(time.Now()))
+			status.NextScheduleTime = &next
+		}
+		return status, nil
+	}
+
+	// CronJobs don't support time zones, so the schedule is translated to
+	// UTC and the CronJob is updated when the zone changes its offset
+	if scheduled.Spec.TimeZone != "" {
+		loc, _ := time.LoadLocation(scheduled.Spec.TimeZone)
+		scheduled.Spec.Schedule, err = cronschedule.ToUTC(scheduled.Spec.Schedule, loc, time.Now())
+		if err != nil {
+			return fail("UnsupportedTimeZone", true, err)
+		}
+	}
+	status.EffectiveSchedule = scheduled.Spec.Schedule
+
+	cronjob := &v1beta1.CronJob{}
+	cronjobName := resources.MakeCronJobName(backup.Name, name)
+	status.CronjobName = fmt.Sprintf("%s/%s", backup.Namespace, cronjobName)
+	err = r.Get(ctx, client.ObjectKey{Namespace: backup.Namespace, Name: cronjobName}, cronjob)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fail("CronJobFailed", false, err)
+	}
+
+	// CronJob starts the latest run missed while it was suspended, so after
+	// a Skip window it stays suspended until the first run scheduled after
+	// the window, which it starts instead of the missed ones
+	resumeAt := skipResumeTime(cronjob, schedule, skipUntil)
+	now := time.Now()
+	if resumeAt != nil && now.Before(*resumeAt) {
+		suspend := true
+		scheduled.Spec.Suspend = &suspend
+	} else {
+		resumeAt = nil
+	}
+
+	copybird := resources.NewCopyBirdParams(backupImage(log, scheduled), scheduled)
+	copybird.Schedule = name
+	desired, err := copybird.MakeCronJob(ctx)
+	if err != nil {
+		// invalid pod template can't be fixed by retrying
+		return fail("InvalidPodTemplate", true, err)
+	}
+	if cronjob.CreationTimestamp.IsZero() {
+		cronjob = desired
+	}
+
+	restored := false
 	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, cronjob, func() error {
 		if cronjob.ObjectMeta.CreationTimestamp.IsZero() {
-			if err = controllerutil.SetControllerReference(backup, cronjob, r.Scheme); err != nil {
-				return err
-			}
+			return controllerutil.SetControllerReference(backup, cronjob, r.Scheme)
+		}
+		// resume time is kept on the CronJob, since the closed
+		// window is forgotten by the Backup status
+		if resumeAt != nil {
+			metav1.SetMetaDataAnnotation(&cronjob.ObjectMeta, resources.ResumeAtAnnotation, resumeAt.UTC().Format(time.RFC3339))
 		} else {
-			newCronjob := copybird.MakeCronJob(ctx)
-			cronjob.Spec = newCronjob.Spec
+			delete(cronjob.Annotations, resources.ResumeAtAnnotation)
+		}
+		// changes of the desired spec are tracked by its hash
+		hash := desired.Annotations[resources.SpecHashAnnotation]
+		if cronjob.Annotations[resources.SpecHashAnnotation] == hash {
+			if !cronJobChanged(desired, cronjob) {
+				return nil
+			}
+			log.Info("Cronjob was changed out of band, restoring it", "cronjob", status.CronjobName)
+			restored = true
 		}
+		cronjob.Spec = desired.Spec
+		metav1.SetMetaDataAnnotation(&cronjob.ObjectMeta, resources.SpecHashAnnotation, hash)
 		return nil
 	})
 	if err != nil {
+		return fail("CronJobFailed", false, err)
+	}
+	log.Info("Cronjob successfully reconciled", "cronjob", status.CronjobName, "operation", op)
+	switch {
+	case op == controllerutil.OperationResultCreated:
+		r.Recorder.Eventf(backup, corev1.EventTypeNormal, "CronJobCreated", "Created cronjob %s", desired.Name)
+	case restored:
+		r.Recorder.Eventf(backup, corev1.EventTypeWarning, "CronJobRestored", "Restored cronjob %s changed out of band", desired.Name)
+	case op == controllerutil.OperationResultUpdated:
+		r.Recorder.Eventf(backup, corev1.EventTypeNormal, "CronJobUpdated", "Updated cronjob %s", desired.Name)
+	}
+
+	if !suspended {
+		next := metav1.NewTime(schedule.Next(now))
+		status.NextScheduleTime = &next
+	}
+	return status, nil
+}
+
+// cronJobChanged reports whether the live CronJob was edited out of band.
+// The live spec is defaulted by the API server, so only the fields set in
+// the desired spec are compared, except for the optional ones the server
+// leaves unset, which must stay unset.
+func cronJobChanged(desired, live *v1beta1.CronJob) bool {
+	if !equality.Semantic.DeepDerivative(desired.Spec, live.Spec) {
+		return true
+	}
+	desiredJob, liveJob := desired.Spec.JobTemplate.Spec, live.Spec.JobTemplate.Spec
+	return !equality.Semantic.DeepEqual(desired.Spec.StartingDeadlineSeconds, live.Spec.StartingDeadlineSeconds) ||
+		!equality.Semantic.DeepEqual(desiredJob.ActiveDeadlineSeconds, liveJob.ActiveDeadlineSeconds) ||
+		!equality.Semantic.DeepEqual(desiredJob.TTLSecondsAfterFinished, liveJob.TTLSecondsAfterFinished)
+}
+
+// skipResumeTime returns the time the CronJob is resumed at after a Skip
+// blackout window closed at skipUntil, or the time recorded on the CronJob
+// when an earlier window was closed, whichever is later. It returns nil if
+// the CronJob has no runs to skip.
+func skipResumeTime(cronjob *v1beta1.CronJob, schedule cron.Schedule, skipUntil *metav1.Time) *time.Time {
+	var resumeAt *time.Time
+	if value, ok := cronjob.Annotations[resources.ResumeAtAnnotation]; ok {
+		// the annotation edited out of band is dropped
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			resumeAt = &t
+		}
+	}
+	// new CronJobs and the ones scheduled since the window
+	// closed have no runs to catch up
+	if skipUntil == nil || cronjob.CreationTimestamp.IsZero() ||
+		(cronjob.Status.LastScheduleTime != nil && !cronjob.Status.LastScheduleTime.Before(skipUntil)) {
+		return resumeAt
+	}
+	first := schedule.Next(skipUntil.Add(-time.Second))
+	if resumeAt == nil || first.After(*resumeAt) {
+		resumeAt = &first
+	}
+	return resumeAt
+}
+
+// deleteStaleCronJobs deletes CronJobs of the Backup schedules that were removed
+func (r *BackupReconciler) deleteStaleCronJobs(ctx context.Context, backup *backupv1alpha1.Backup, cronJobs sets.String) error {
+	list := &v1beta1.CronJobList{}
+	if err := r.List(ctx, list, client.InNamespace(backup.Namespace)); err != nil {
 		return err
 	}
-	log.Info("Cronjob successfully reconciled", "operation", op)
+	for i := range list.Items {
+		cronjob := &list.Items[i]
+		if !metav1.IsControlledBy(cronjob, backup) || cronJobs.Has(cronjob.Name) {
+			continue
+		}
+		// finished Jobs of the schedule are deleted along with it
+		err := r.Delete(ctx, cronjob, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		r.Log.Info("Stale cronjob deleted", "cronjob", cronjob.Name)
+		r.Recorder.Eventf(backup, corev1.EventTypeNormal, "CronJobDeleted", "Deleted cronjob %s", cronjob.Name)
+	}
 	return nil
 }
 
-func (r *BackupReconciler) finalize(ctx context.Context, backup *backupv1alpha1.Backup) error {
-	log := r.Log.WithName("finalizer")
-	_ = log
-	finalizers := sets.NewString(backup.Finalizers...)
-	finalizers.Delete(finalizerName)
-	backup.Finalizers = finalizers.List()
+// parseSchedule parses the Backup schedule interpreted in its time zone.
+// H tokens are expanded from the Backup and schedule names, so Backups
+// sharing a schedule don't all run at once. The expanded schedule is
+// returned along with the parsed one.
+func parseSchedule(backup *backupv1alpha1.Backup, name string) (string, cron.Schedule, error) {
+	key := fmt.Sprintf("%s/%s", backup.Namespace, backup.Name)
+	if name != "" {
+		key = fmt.Sprintf("%s/%s", key, name)
+	}
+	spec, err := cronschedule.Hash(backup.Spec.Schedule, key)
+	if err != nil {
+		return "", nil, err
+	}
+	schedule, err := parseCron(spec, backup.Spec.TimeZone)
+	return spec, schedule, err
+}
+
+// parseCron parses the cron schedule interpreted in the time zone
+func parseCron(spec, timeZone string) (cron.Schedule, error) {
+	if timeZone == "" {
+		return cron.ParseStandard(spec)
+	}
+	if _, err := time.LoadLocation(timeZone); err != nil {
+		return nil, err
+	}
+	return cron.ParseStandard(fmt.Sprintf("CRON_TZ=%s %s", timeZone, spec))
+}
+
+// reconcileRunNow starts a backup Job once the run-now annotation of the
+// Backup changes. The run is started even if the Backup is suspended, as
+// it is requested explicitly.
+func (r *BackupReconciler) reconcileRunNow(ctx context.Context, backup, resolved *backupv1alpha1.Backup) error {
+	trigger := backup.Annotations[backupv1alpha1.RunNowAnnotation]
+	if trigger == "" || (backup.Status.RunNow != nil && backup.Status.RunNow.Trigger == trigger) {
+		return nil
+	}
+
+	log := r.Log.WithName("reconciler")
+	copybird := resources.NewCopyBirdParams(backupImage(log, resolved), resolved)
+	job, err := copybird.MakeRunNowJob(ctx, trigger)
+	if err != nil {
+		// invalid pod template is reported by reconcileSchedule and
+		// the run is started once it is fixed
+		return nil
+	}
+	if err := controllerutil.SetControllerReference(backup, job, r.Scheme); err != nil {
+		return err
+	}
+	// the Job may be already created if the status update failed
+	if err := r.Create(ctx, job); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	log.Info("Run now job created", "job", job.Name, "trigger", trigger)
+
+	backup.Status.RunNow = &backupv1alpha1.RunNowStatus{
+		Trigger:     trigger,
+		JobName:     job.Name,
+		TriggerTime: metav1.Now(),
+	}
 	return nil
 }
 
+// reconcileSuspend sets Suspended condition and suspends the resolved Backup
+// while the controller is in maintenance mode or a blackout window is open.
+// The Backup spec is left intact, so its own suspend state is restored once
+// maintenance or the window is over.
+func (r *BackupReconciler) reconcileSuspend(ctx context.Context, backup, resolved *backupv1alpha1.Backup, blackout *backupv1alpha1.BlackoutStatus) (bool, error) {
+	maintenance, err := r.inMaintenance(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case maintenance:
+		suspend := true
+		resolved.Spec.Suspend = &suspend
+		setBackupCondition(backup, backupv1alpha1.ConditionSuspended, corev1.ConditionTrue,
+			"Maintenance", "controller is in maintenance mode")
+		return true, nil
+	case resolved.Spec.Suspend != nil && *resolved.Spec.Suspend:
+		setBackupCondition(backup, backupv1alpha1.ConditionSuspended, corev1.ConditionTrue,
+			"SuspendedBySpec", "backup is suspended")
+		return true, nil
+	case blackout != nil:
+		suspend := true
+		resolved.Spec.Suspend = &suspend
+		setBackupCondition(backup, backupv1alpha1.ConditionSuspended, corev1.ConditionTrue,
+			"BlackoutWindow", fmt.Sprintf("blackout window %q is open until %s", blackout.Window, blackout.End.UTC().Format(time.RFC3339)))
+		return true, nil
+	default:
+		setBackupCondition(backup, backupv1alpha1.ConditionSuspended, corev1.ConditionFalse, "Active", "")
+		return false, nil
+	}
+}
+
+// inMaintenance returns true if the maintenance ConfigMap suspends all Backups
+func (r *BackupReconciler) inMaintenance(ctx context.Context) (bool, error) {
+	if r.MaintenanceConfigMap.Name == "" {
+		return false, nil
+	}
+	configMap := &corev1.ConfigMap{}
+	err := r.maintenanceReader.Get(ctx, r.MaintenanceConfigMap, configMap)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	maintenance, err := strconv.ParseBool(configMap.Data[maintenanceSuspendKey])
+	if err != nil {
+		return false, nil
+	}
+	return maintenance, nil
+}
+
+// reconcileSecrets sets SecretsResolved condition checking secrets
+// referenced by all modules of the resolved Backup and its schedules
+func (r *BackupReconciler) reconcileSecrets(ctx context.Context, backup, resolved *backupv1alpha1.Backup) error {
+	modules := []backupv1alpha1.Module{resolved.Spec.Input, resolved.Spec.Output, resolved.Spec.Compress, resolved.Spec.Encrypt}
+	for _, schedule := range resolved.Spec.Schedules {
+		if schedule.Output != nil {
+			modules = append(modules, scheduleBackup(resolved, schedule.Name).Spec.Output)
+		}
+	}
+	for _, module := range modules {
+		reason, message, err := checkModuleSecrets(ctx, r.APIReader, backup.Namespace, module)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			if backupConditionChanged(backup, backupv1alpha1.ConditionSecretsResolved, corev1.ConditionFalse, reason, message) {
+				r.Recorder.Event(backup, corev1.EventTypeWarning, reason, message)
+			}
+			setBackupCondition(backup, backupv1alpha1.ConditionSecretsResolved, corev1.ConditionFalse, reason, message)
+			return nil
+		}
+	}
+	if condition := backupv1alpha1.FindCondition(backup.Status.Conditions, backupv1alpha1.ConditionSecretsResolved); condition != nil && condition.Status == corev1.ConditionFalse {
+		r.Recorder.Event(backup, corev1.EventTypeNormal, "SecretsFound", "All referenced secrets found")
+	}
+	setBackupCondition(backup, backupv1alpha1.ConditionSecretsResolved, corev1.ConditionTrue, "SecretsFound", "")
+	return nil
+}
+
+// addFinalizer makes sure the Backup is finalized by the controller
+func (r *BackupReconciler) addFinalizer(ctx context.Context, backup *backupv1alpha1.Backup) error {
+	// other controllers may have added their finalizers already
+	if sets.NewString(backup.GetFinalizers()...).Has(finalizerName) {
+		return nil
+	}
+	return patchMetadata(ctx, r.Client, backup, func() {
+		finalizers := sets.NewString(backup.Finalizers...)
+		finalizers.Insert(finalizerName)
+		backup.Finalizers = finalizers.List()
+	})
+}
+
+// finalize removes the Backup finalizer once its artifacts are handled by
+// the deletion policy. It returns true if the finalizer is removed.
+func (r *BackupReconciler) finalize(ctx context.Context, backup *backupv1alpha1.Backup) (bool, error) {
+	if !sets.NewString(backup.Finalizers...).Has(finalizerName) {
+		return true, nil
+	}
+	if backup.Spec.DeletionPolicy == backupv1alpha1.DeletionPolicyDelete {
+		// the Backup is reconciled again once cleanup Jobs finish
+		done, err := r.cleanup(ctx, backup)
+		if err != nil || !done {
+			return false, err
+		}
+	}
+	err := patchMetadata(ctx, r.Client, backup, func() {
+		finalizers := sets.NewString(backup.Finalizers...)
+		finalizers.Delete(finalizerName)
+		backup.Finalizers = finalizers.List()
+	})
+	if err != nil {
+		return false, err
+	}
+	r.Recorder.Eventf(backup, corev1.EventTypeNormal, "Finalized", "Backup finalized, artifacts %s", finalizedArtifacts(backup))
+	return true, nil
+}
+
+// finalizedArtifacts describes what happened to artifacts of the deleted Backup
+func finalizedArtifacts(backup *backupv1alpha1.Backup) string {
+	if backup.Spec.DeletionPolicy == backupv1alpha1.DeletionPolicyDelete {
+		return "removed"
+	}
+	return "retained"
+}
+
+// GetCopybirdImage returns copybird image name from the controller environment
+func GetCopybirdImage(log logr.Logger) string {
+	copybirdImage, defined := os.LookupEnv(copybirdImageEnvVar)
+	if !defined {
+		log.Info("environment variable \"" + copybirdImageEnvVar + "\" not defined, using default value: \"" + copybirdDefaultImage)
+		copybirdImage = copybirdDefaultImage
+	}
+	return copybirdImage
+}
+
+// backupImage returns copybird image used by the Backup Jobs
+func backupImage(log logr.Logger, backup *backupv1alpha1.Backup) string {
+	if backup.Spec.Image != "" {
+		return backup.Spec.Image
+	}
+	return GetCopybirdImage(log)
+}
+
+// backupsForStorageLocation maps a storage location to the Backups referring to it
+func (r *BackupReconciler) backupsForStorageLocation(kind string) handler.ToRequestsFunc {
+	return func(obj handler.MapObject) []reconcile.Request {
+		backups := &backupv1alpha1.BackupList{}
+		if err := r.List(context.Background(), backups, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+			r.Log.Info("can't list backups", "reason", err)
+			return nil
+		}
+		var requests []reconcile.Request
+		for _, backup := range backups.Items {
+			ref := backup.Spec.StorageLocation
+			if ref == nil || ref.Name != obj.Meta.GetName() {
+				continue
+			}
+			if ref.Kind != kind && !(ref.Kind == "" && kind == backupv1alpha1.BackupStorageLocationKind) {
+				continue
+			}
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: backup.Name, Namespace: backup.Namespace},
+			})
+		}
+		return requests
+	}
+}
+
+// backupsForClass maps a BackupClass to the Backups using it, either
+// explicitly or as the default class
+func (r *BackupReconciler) backupsForClass(obj handler.MapObject) []reconcile.Request {
+	backups := &backupv1alpha1.BackupList{}
+	if err := r.List(context.Background(), backups); err != nil {
+		r.Log.Info("can't list backups", "reason", err)
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, backup := range backups.Items {
+		if backup.Spec.BackupClassName != "" && backup.Spec.BackupClassName != obj.Meta.GetName() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: backup.Name, Namespace: backup.Namespace},
+		})
+	}
+	return requests
+}
+
+// backupsForMaintenance maps the maintenance ConfigMap to all Backups
+func (r *BackupReconciler) backupsForMaintenance(obj handler.MapObject) []reconcile.Request {
+	if obj.Meta.GetName() != r.MaintenanceConfigMap.Name || obj.Meta.GetNamespace() != r.MaintenanceConfigMap.Namespace {
+		return nil
+	}
+	backups := &backupv1alpha1.BackupList{}
+	if err := r.List(context.Background(), backups); err != nil {
+		r.Log.Info("can't list backups", "reason", err)
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, backup := range backups.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: backup.Name, Namespace: backup.Namespace},
+		})
+	}
+	return requests
+}
+
 func (r *BackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&backupv1alpha1.Backup{}).
-		Complete(r)
+		// deleted or edited CronJobs are restored and
+		// the Jobs started by the controller are followed
+		Owns(&v1beta1.CronJob{}).
+		Owns(&v1.Job{}).
+		Watches(&source.Kind{Type: &backupv1alpha1.BackupStorageLocation{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: r.backupsForStorageLocation(backupv1alpha1.BackupStorageLocationKind),
+		}).
+		Watches(&source.Kind{Type: &backupv1alpha1.ClusterBackupStorageLocation{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: r.backupsForStorageLocation(backupv1alpha1.ClusterBackupStorageLocationKind),
+		}).
+		Watches(&source.Kind{Type: &backupv1alpha1.BackupClass{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.backupsForClass),
+		})
+	if r.MaintenanceConfigMap.Name != "" {
+		// only ConfigMaps of the maintenance ConfigMap namespace are
+		// cached, rather than all ConfigMaps of the cluster
+		configMaps, err := cache.New(mgr.GetConfig(), cache.Options{
+			Scheme:    mgr.GetScheme(),
+			Mapper:    mgr.GetRESTMapper(),
+			Namespace: r.MaintenanceConfigMap.Namespace,
+		})
+		if err != nil {
+			return err
+		}
+		if err := mgr.Add(configMaps); err != nil {
+			return err
+		}
+		r.maintenanceReader = configMaps
+		kind := &source.Kind{Type: &corev1.ConfigMap{}}
+		if err := kind.InjectCache(configMaps); err != nil {
+			return err
+		}
+		builder = builder.Watches(kind, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.backupsForMaintenance),
+		})
+	}
+	return builder.Complete(r)
 }
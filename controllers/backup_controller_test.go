@@ -0,0 +1,304 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"github.com/copybird/copybird-crd/controllers/resources"
+	"k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestBackupReconciler returns BackupReconciler backed by a fake client
+// holding the objects
+func newTestBackupReconciler(t *testing.T, objects ...runtime.Object) *BackupReconciler {
+	scheme := testScheme(t)
+	c := fake.NewFakeClientWithScheme(scheme, objects...)
+	return &BackupReconciler{
+		Client:    c,
+		Log:       ctrl.Log.WithName("test"),
+		Scheme:    scheme,
+		Recorder:  record.NewFakeRecorder(100),
+		APIReader: c,
+	}
+}
+
+func TestReconcileHoldsOnlySchedulesCatchingUpSkipWindow(t *testing.T) {
+	now := time.Now()
+	// the main schedule has no run since the window closed,
+	// its next run is two hours away
+	next := now.Add(2 * time.Hour)
+	backup := &backupv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysql-backup", Namespace: "db"},
+		Spec: backupv1alpha1.BackupSpec{
+			Schedule:  fmt.Sprintf("%d %d * * *", next.Minute(), next.Hour()),
+			Schedules: []backupv1alpha1.BackupSchedule{{Name: "hourly", Schedule: "*/5 * * * *"}},
+			Input:     backupv1alpha1.Module{Type: "mysql"},
+			Output:    backupv1alpha1.Module{Type: "s3"},
+		},
+		Status: backupv1alpha1.BackupStatus{
+			// the Skip window closed a minute ago
+			Blackout: &backupv1alpha1.BlackoutStatus{
+				Window: "month-end",
+				Policy: backupv1alpha1.BlackoutPolicySkip,
+				Start:  metav1.NewTime(now.Add(-3 * time.Hour)),
+				End:    metav1.NewTime(now.Add(-time.Minute)),
+			},
+		},
+	}
+	cronJob := func(name string, lastSchedule time.Time) *v1beta1.CronJob {
+		last := metav1.NewTime(lastSchedule)
+		return &v1beta1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         backup.Namespace,
+				CreationTimestamp: metav1.NewTime(now.Add(-24 * time.Hour)),
+			},
+			Status: v1beta1.CronJobStatus{LastScheduleTime: &last},
+		}
+	}
+	main := resources.MakeCronJobName(backup.Name, "")
+	hourly := resources.MakeCronJobName(backup.Name, "hourly")
+	r := newTestBackupReconciler(t, backup,
+		cronJob(main, now.Add(-4*time.Hour)),
+		cronJob(hourly, now.Add(-30*time.Second)))
+
+	if err := r.reconcile(context.Background(), backup); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, held := range map[string]bool{main: true, hourly: false} {
+		cronjob := &v1beta1.CronJob{}
+		if err := r.Get(context.Background(), types.NamespacedName{Name: name, Namespace: backup.Namespace}, cronjob); err != nil {
+			t.Fatalf("can't get cronjob %s: %v", name, err)
+		}
+		suspended := cronjob.Spec.Suspend != nil && *cronjob.Spec.Suspend
+		if suspended != held {
+			t.Errorf("cronjob %s: expected suspended %v, got %v", name, held, suspended)
+		}
+		if _, ok := cronjob.Annotations[resources.ResumeAtAnnotation]; ok != held {
+			t.Errorf("cronjob %s: expected resume time %v, got annotations %v", name, held, cronjob.Annotations)
+		}
+	}
+}
+
+func TestAddFinalizerNextToOtherFinalizers(t *testing.T) {
+	backup := &backupv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "mysql-backup",
+			Namespace:  "db",
+			Finalizers: []string{"example.com/protect"},
+		},
+	}
+	r := newTestBackupReconciler(t, backup)
+
+	if err := r.addFinalizer(context.Background(), backup); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored := &backupv1alpha1.Backup{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: backup.Name, Namespace: backup.Namespace}, stored); err != nil {
+		t.Fatalf("can't get backup: %v", err)
+	}
+	finalizers := sets.NewString(stored.Finalizers...)
+	if !finalizers.HasAll(finalizerName, "example.com/protect") {
+		t.Errorf("unexpected finalizers: %v", stored.Finalizers)
+	}
+}
+
+func TestReconcileRestoresCronJobChangedOutOfBand(t *testing.T) {
+	int64Ptr := func(v int64) *int64 { return &v }
+	int32Ptr := func(v int32) *int32 { return &v }
+
+	tests := map[string]struct {
+		edit func(cronjob *v1beta1.CronJob)
+		// restored is false for the edits made by the API server defaults
+		restored bool
+	}{
+		"suspended": {
+			edit: func(cronjob *v1beta1.CronJob) {
+				suspend := true
+				cronjob.Spec.Suspend = &suspend
+			},
+			restored: true,
+		},
+		"history limit": {
+			edit:     func(cronjob *v1beta1.CronJob) { cronjob.Spec.SuccessfulJobsHistoryLimit = int32Ptr(100) },
+			restored: true,
+		},
+		"starting deadline": {
+			edit:     func(cronjob *v1beta1.CronJob) { cronjob.Spec.StartingDeadlineSeconds = int64Ptr(10) },
+			restored: true,
+		},
+		"defaulted": {
+			edit: func(cronjob *v1beta1.CronJob) {
+				cronjob.Spec.JobTemplate.Spec.BackoffLimit = int32Ptr(6)
+				cronjob.Spec.JobTemplate.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirst
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			backup := &backupv1alpha1.Backup{
+				ObjectMeta: metav1.ObjectMeta{Name: "mysql-backup", Namespace: "db"},
+				Spec: backupv1alpha1.BackupSpec{
+					Schedule: "0 3 * * *",
+					Input:    backupv1alpha1.Module{Type: "mysql"},
+					Output:   backupv1alpha1.Module{Type: "s3"},
+				},
+			}
+			r := newTestBackupReconciler(t, backup)
+			key := types.NamespacedName{Name: resources.MakeCronJobName(backup.Name, ""), Namespace: backup.Namespace}
+			if err := r.reconcile(context.Background(), backup.DeepCopy()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			created := &v1beta1.CronJob{}
+			if err := r.Get(context.Background(), key, created); err != nil {
+				t.Fatalf("can't get cronjob: %v", err)
+			}
+
+			edited := created.DeepCopy()
+			edited.CreationTimestamp = metav1.Now()
+			test.edit(edited)
+			if err := r.Update(context.Background(), edited); err != nil {
+				t.Fatalf("can't edit cronjob: %v", err)
+			}
+			if err := r.reconcile(context.Background(), backup.DeepCopy()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			reconciled := &v1beta1.CronJob{}
+			if err := r.Get(context.Background(), key, reconciled); err != nil {
+				t.Fatalf("can't get cronjob: %v", err)
+			}
+			expected := edited.Spec
+			if test.restored {
+				expected = created.Spec
+			}
+			if !equality.Semantic.DeepEqual(reconciled.Spec, expected) {
+				t.Errorf("unexpected cronjob spec: %s", diff.ObjectReflectDiff(expected, reconciled.Spec))
+			}
+		})
+	}
+}
+
+func TestReconcileSecretsReadsAPIServer(t *testing.T) {
+	backup := &backupv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysql-backup", Namespace: "db"},
+		Spec: backupv1alpha1.BackupSpec{
+			Input: backupv1alpha1.Module{Type: "mysql"},
+			Output: backupv1alpha1.Module{Type: "s3", Secrets: []backupv1alpha1.ModuleSecret{{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "aws"},
+					Key:                  "accesskeyid",
+				},
+			}}},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "aws", Namespace: "db"},
+		Data:       map[string][]byte{"accesskeyid": []byte("key")},
+	}
+
+	tests := map[string]struct {
+		objects []runtime.Object
+		status  corev1.ConditionStatus
+		reason  string
+	}{
+		"secret found": {
+			objects: []runtime.Object{secret},
+			status:  corev1.ConditionTrue,
+			reason:  "SecretsFound",
+		},
+		"secret missing": {
+			status: corev1.ConditionFalse,
+			reason: "SecretNotFound",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := newTestBackupReconciler(t, backup)
+			// Secrets aren't cached, so they are only seen by the API reader
+			r.APIReader = fake.NewFakeClientWithScheme(r.Scheme, test.objects...)
+			desired := backup.DeepCopy()
+			if err := r.reconcileSecrets(context.Background(), desired, desired); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			condition := backupv1alpha1.FindCondition(desired.Status.Conditions, backupv1alpha1.ConditionSecretsResolved)
+			if condition == nil || condition.Status != test.status || condition.Reason != test.reason {
+				t.Errorf("expected %s condition %s/%s, got %+v", backupv1alpha1.ConditionSecretsResolved, test.status, test.reason, condition)
+			}
+		})
+	}
+}
+
+func TestInMaintenance(t *testing.T) {
+	key := types.NamespacedName{Name: "copybird-maintenance", Namespace: "copybird"}
+	configMap := func(data map[string]string) runtime.Object {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+			Data:       data,
+		}
+	}
+
+	tests := map[string]struct {
+		objects  []runtime.Object
+		expected bool
+	}{
+		"no configmap": {},
+		"suspended": {
+			objects:  []runtime.Object{configMap(map[string]string{maintenanceSuspendKey: "true"})},
+			expected: true,
+		},
+		"resumed": {
+			objects: []runtime.Object{configMap(map[string]string{maintenanceSuspendKey: "false"})},
+		},
+		"invalid value": {
+			objects: []runtime.Object{configMap(map[string]string{maintenanceSuspendKey: "yes please"})},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := newTestBackupReconciler(t)
+			r.MaintenanceConfigMap = key
+			r.maintenanceReader = fake.NewFakeClientWithScheme(r.Scheme, test.objects...)
+			maintenance, err := r.inMaintenance(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if maintenance != test.expected {
+				t.Errorf("expected maintenance %v, got %v", test.expected, maintenance)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// setBackupCondition sets the Backup condition observed at the current generation
+func setBackupCondition(backup *backupv1alpha1.Backup, conditionType string, status corev1.ConditionStatus, reason, message string) {
+	backupv1alpha1.SetCondition(&backup.Status.Conditions, backupv1alpha1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: backup.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// backupConditionChanged reports whether setting the Backup condition
+// changes its status, reason or message, so it is worth an Event
+func backupConditionChanged(backup *backupv1alpha1.Backup, conditionType string, status corev1.ConditionStatus, reason, message string) bool {
+	condition := backupv1alpha1.FindCondition(backup.Status.Conditions, conditionType)
+	return condition == nil || condition.Status != status || condition.Reason != reason || condition.Message != message
+}
+
+// applyScheduleStatus copies status fields owned by BackupReconciler
+// from the reconciled status
+func applyScheduleStatus(backup *backupv1alpha1.Backup, status *backupv1alpha1.BackupStatus) {
+	backup.Status.ObservedGeneration = status.ObservedGeneration
+	backup.Status.NextScheduleTime = status.NextScheduleTime
+	backup.Status.EffectiveSchedule = status.EffectiveSchedule
+	backup.Status.LastScheduleTime = status.LastScheduleTime
+	// last successful times of the schedules are owned by JobReconciler
+	schedules := make([]backupv1alpha1.ScheduleStatus, 0, len(status.Schedules))
+	for _, schedule := range status.Schedules {
+		if current := findScheduleStatus(backup.Status.Schedules, schedule.Name); current != nil {
+			schedule.LastSuccessfulTime = current.LastSuccessfulTime
+		}
+		schedules = append(schedules, schedule)
+	}
+	if len(schedules) == 0 {
+		schedules = nil
+	}
+	backup.Status.Schedules = schedules
+	backup.Status.Blackout = status.Blackout
+	backup.Status.SkippedRuns = status.SkippedRuns
+	backup.Status.RunNow = status.RunNow
+	backup.Status.CronjobName = status.CronjobName
+	for _, conditionType := range []string{backupv1alpha1.ConditionScheduled, backupv1alpha1.ConditionSecretsResolved,
+		backupv1alpha1.ConditionSuspended, backupv1alpha1.ConditionStale, backupv1alpha1.ConditionDeletionPolicyAccepted} {
+		if condition := backupv1alpha1.FindCondition(status.Conditions, conditionType); condition != nil {
+			backupv1alpha1.SetCondition(&backup.Status.Conditions, *condition)
+		}
+	}
+}
+
+// updateBackupPhase derives Ready condition and phase from the rest of the Backup status
+func updateBackupPhase(backup *backupv1alpha1.Backup) {
+	ready := corev1.ConditionTrue
+	reason, message := "Ready", ""
+	for _, conditionType := range []string{backupv1alpha1.ConditionScheduled, backupv1alpha1.ConditionSecretsResolved} {
+		condition := backupv1alpha1.FindCondition(backup.Status.Conditions, conditionType)
+		if condition == nil {
+			ready, reason, message = corev1.ConditionUnknown, "Pending", fmt.Sprintf("%s condition is not reported yet", conditionType)
+			break
+		}
+		if condition.Status != corev1.ConditionTrue {
+			ready, reason, message = corev1.ConditionFalse, condition.Reason, condition.Message
+			break
+		}
+	}
+	setBackupCondition(backup, backupv1alpha1.ConditionReady, ready, reason, message)
+
+	switch {
+	case ready == corev1.ConditionUnknown:
+		backup.Status.Phase = backupv1alpha1.BackupPhasePending
+	case ready == corev1.ConditionFalse:
+		backup.Status.Phase = backupv1alpha1.BackupPhaseError
+	case backupv1alpha1.IsConditionTrue(backup.Status.Conditions, backupv1alpha1.ConditionSuspended):
+		backup.Status.Phase = backupv1alpha1.BackupPhaseSuspended
+	case backup.Status.ConsecutiveFailures > 0:
+		backup.Status.Phase = backupv1alpha1.BackupPhaseFailing
+	case backup.Status.LastSuccessfulTime == nil:
+		backup.Status.Phase = backupv1alpha1.BackupPhasePending
+	default:
+		backup.Status.Phase = backupv1alpha1.BackupPhaseActive
+	}
+}
+
+// recordJobStatus puts the Job status into the list of the latest Jobs,
+// which is ordered by start time. It returns true if the Job has finished
+// since it was recorded last time.
+func recordJobStatus(status *backupv1alpha1.BackupStatus, jobStatus backupv1alpha1.JobStatus) bool {
+	found, wasFinished := false, false
+	for i, statusJob := range status.Jobs {
+		if statusJob.Name == jobStatus.Name {
+			found, wasFinished = true, statusJob.FinishTime != nil
+			status.Jobs[i] = jobStatus
+			break
+		}
+	}
+	if !found {
+		status.Jobs = append(status.Jobs, jobStatus)
+	}
+
+	sort.SliceStable(status.Jobs, func(i, j int) bool {
+		a, b := status.Jobs[i].StartTime, status.Jobs[j].StartTime
+		if a == nil || b == nil {
+			return a == nil && b != nil
+		}
+		return b.Before(a)
+	})
+	if len(status.Jobs) > numberOfJobsToShow {
+		status.Jobs = status.Jobs[:numberOfJobsToShow]
+	}
+
+	if !found {
+		// an old Job seen again after resync falls off the list
+		// and must not be accounted as a new run
+		for _, statusJob := range status.Jobs {
+			if statusJob.Name == jobStatus.Name {
+				found = true
+				break
+			}
+		}
+	}
+	return found && jobStatus.FinishTime != nil && !wasFinished
+}
+
+// recordSkippedRun puts the run into the list of the latest skipped runs,
+// which is ordered by scheduled time
+func recordSkippedRun(status *backupv1alpha1.BackupStatus, run backupv1alpha1.SkippedRun) {
+	for _, skipped := range status.SkippedRuns {
+		if skipped.Schedule == run.Schedule && skipped.ScheduledTime.Equal(&run.ScheduledTime) {
+			return
+		}
+	}
+	status.SkippedRuns = append(status.SkippedRuns, run)
+	sort.SliceStable(status.SkippedRuns, func(i, j int) bool {
+		return status.SkippedRuns[j].ScheduledTime.Before(&status.SkippedRuns[i].ScheduledTime)
+	})
+	if len(status.SkippedRuns) > numberOfJobsToShow {
+		status.SkippedRuns = status.SkippedRuns[:numberOfJobsToShow]
+	}
+}
+
+// observeFinishedRun updates Backup run statistics with the outcome of a finished Job
+func observeFinishedRun(backup *backupv1alpha1.Backup, jobStatus backupv1alpha1.JobStatus, reason string) {
+	if jobStatus.Success {
+		backup.Status.ConsecutiveFailures = 0
+		if backup.Status.LastSuccessfulTime == nil || backup.Status.LastSuccessfulTime.Before(jobStatus.FinishTime) {
+			backup.Status.LastSuccessfulTime = jobStatus.FinishTime
+			backup.Status.LatestBackupTimestamp = jobStatus.FinishTime.UTC().Format(time.RFC3339)
+			// runs of older copybird images don't report the artifact
+			backup.Status.LatestArtifact = ""
+			if jobStatus.Result != nil {
+				backup.Status.LatestArtifact = jobStatus.Result.ArtifactURI
+			}
+		}
+		if schedule := findScheduleStatus(backup.Status.Schedules, jobStatus.Schedule); schedule != nil {
+			if schedule.LastSuccessfulTime == nil || schedule.LastSuccessfulTime.Before(jobStatus.FinishTime) {
+				schedule.LastSuccessfulTime = jobStatus.FinishTime
+			}
+		}
+		setBackupCondition(backup, backupv1alpha1.ConditionLastRunSucceeded, corev1.ConditionTrue,
+			"JobSucceeded", fmt.Sprintf("job %s succeeded", jobStatus.Name))
+	} else {
+		backup.Status.ConsecutiveFailures++
+		setBackupCondition(backup, backupv1alpha1.ConditionLastRunSucceeded, corev1.ConditionFalse,
+			"JobFailed", fmt.Sprintf("job %s failed: %s", jobStatus.Name, reason))
+	}
+	updateBackupPhase(backup)
+}
+
+// findJobStatus returns the status of the named Job if it is listed
+func findJobStatus(jobs []backupv1alpha1.JobStatus, name string) *backupv1alpha1.JobStatus {
+	for i := range jobs {
+		if jobs[i].Name == name {
+			return &jobs[i]
+		}
+	}
+	return nil
+}
+
+// findScheduleStatus returns the status of the named additional schedule
+func findScheduleStatus(schedules []backupv1alpha1.ScheduleStatus, name string) *backupv1alpha1.ScheduleStatus {
+	if name == "" {
+		return nil
+	}
+	for i := range schedules {
+		if schedules[i].Name == name {
+			return &schedules[i]
+		}
+	}
+	return nil
+}
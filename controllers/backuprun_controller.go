@@ -0,0 +1,161 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"github.com/copybird/copybird-crd/controllers/resources"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// BackupRunReconciler reconciles a BackupRun object
+type BackupRunReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=copybird.org,resources=backupruns,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=copybird.org,resources=backupruns/status,verbs=get;update;patch
+
+// Reconcile implements controller reconcilation logic
+func (r *BackupRunReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("backuprun", req.NamespacedName)
+
+	run := &backupv1alpha1.BackupRun{}
+	result := ctrl.Result{
+		Requeue: false,
+	}
+
+	if err := r.Get(ctx, req.NamespacedName, run); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("Object not in the queue", "object", req.NamespacedName)
+		} else {
+			log.Error(err, "Failed to get runtime object from request")
+		}
+		return result, nil
+	}
+
+	switch run.Status.Phase {
+	case backupv1alpha1.RunPhaseSucceeded, backupv1alpha1.RunPhaseFailed:
+		// run is a one-shot operation, nothing to do once it is finished
+		return result, nil
+	}
+
+	desired := run.DeepCopy()
+	if err := r.reconcile(ctx, desired); err != nil {
+		result.Requeue = true
+		log.Info("reconcilation error", "reason", err)
+		return result, err
+	}
+
+	err := patchStatus(ctx, r.Client, run, func() {
+		run.Status = desired.Status
+	})
+	if err != nil {
+		result.Requeue = true
+		log.Info("failed to update object status", "reason", err)
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (r *BackupRunReconciler) reconcile(ctx context.Context, run *backupv1alpha1.BackupRun) error {
+	log := r.Log.WithName("reconciler")
+
+	job := &v1.Job{}
+	if run.Status.JobName == "" {
+		backup := &backupv1alpha1.Backup{}
+		err := r.Get(ctx, types.NamespacedName{Name: run.Spec.BackupName, Namespace: run.Namespace}, backup)
+		if apierrors.IsNotFound(err) {
+			run.Status.Phase = backupv1alpha1.RunPhaseFailed
+			run.Status.Reason = fmt.Sprintf("backup %q not found", run.Spec.BackupName)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// the run is rendered from the effective configuration,
+		// like the scheduled ones
+		resolved, err := resolveBackup(ctx, r.Client, backup)
+		if err != nil {
+			run.Status.Phase = backupv1alpha1.RunPhaseFailed
+			run.Status.Reason = fmt.Sprintf("can't resolve backup %q: %v", backup.Name, err)
+			return nil
+		}
+
+		copybird := resources.NewCopyBirdParams(backupImage(log, resolved), resolved)
+		job, err = copybird.MakeJob(ctx, run.Name)
+		if err != nil {
+			run.Status.Phase = backupv1alpha1.RunPhaseFailed
+			run.Status.Reason = fmt.Sprintf("can't render backup job: %v", err)
+			return nil
+		}
+		if err := controllerutil.SetControllerReference(run, job, r.Scheme); err != nil {
+			return err
+		}
+		err = r.Create(ctx, job)
+		if apierrors.IsAlreadyExists(err) {
+			if err := r.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, job); err != nil {
+				return err
+			}
+			if !metav1.IsControlledBy(job, run) {
+				run.Status.Phase = backupv1alpha1.RunPhaseFailed
+				run.Status.Reason = fmt.Sprintf("job %q already exists", job.Name)
+				return nil
+			}
+		} else if err != nil {
+			return err
+		}
+		log.Info("Backup job created", "job", job.Name)
+		run.Status.JobName = job.Name
+	} else {
+		err := r.Get(ctx, types.NamespacedName{Name: run.Status.JobName, Namespace: run.Namespace}, job)
+		if apierrors.IsNotFound(err) {
+			run.Status.Phase = backupv1alpha1.RunPhaseFailed
+			run.Status.Reason = fmt.Sprintf("backup job %q not found", run.Status.JobName)
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	run.Status.Phase, run.Status.Reason = jobPhase(job)
+	run.Status.StartTime = job.Status.StartTime
+	run.Status.FinishTime = jobFinishTime(job)
+	return nil
+}
+
+func (r *BackupRunReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&backupv1alpha1.BackupRun{}).
+		Owns(&v1.Job{}).
+		Complete(r)
+}
@@ -0,0 +1,319 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"github.com/copybird/copybird-crd/controllers/resources"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/diff"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// testScheme returns the scheme of the objects reconciled by the controllers
+func testScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("can't build scheme: %v", err)
+	}
+	if err := backupv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("can't build scheme: %v", err)
+	}
+	return scheme
+}
+
+// containerEnv returns env of the copybird container as a map
+func containerEnv(job *v1.Job) map[string]string {
+	env := map[string]string{}
+	for _, container := range job.Spec.Template.Spec.Containers {
+		if container.Name != resources.ContainerName {
+			continue
+		}
+		for _, v := range container.Env {
+			env[v.Name] = v.Value
+		}
+	}
+	return env
+}
+
+func TestBackupRunResolvesBackup(t *testing.T) {
+	location := &backupv1alpha1.BackupStorageLocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "s3", Namespace: "db"},
+		Spec: backupv1alpha1.BackupStorageLocationSpec{
+			Output: backupv1alpha1.Module{Type: "s3"},
+		},
+		Status: backupv1alpha1.BackupStorageLocationStatus{
+			Conditions: []backupv1alpha1.Condition{
+				{Type: backupv1alpha1.ConditionAvailable, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+	class := &backupv1alpha1.BackupClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "encrypted"},
+		Spec: backupv1alpha1.BackupClassSpec{
+			Encrypt: backupv1alpha1.Module{Type: "aesgcm"},
+		},
+	}
+
+	tests := map[string]struct {
+		objects []runtime.Object
+		phase   backupv1alpha1.RunPhase
+		env     map[string]string
+	}{
+		"resolved": {
+			objects: []runtime.Object{location, class},
+			phase:   backupv1alpha1.RunPhasePending,
+			env: map[string]string{
+				"COPYBIRD_OUTPUT":   "s3",
+				"COPYBIRD_ENCRYPT":  "aesgcm",
+				"COPYBIRD_COMPRESS": backupv1alpha1.DefaultCompressType,
+			},
+		},
+		"storage location missing": {
+			objects: []runtime.Object{class},
+			phase:   backupv1alpha1.RunPhaseFailed,
+		},
+		"class missing": {
+			objects: []runtime.Object{location},
+			phase:   backupv1alpha1.RunPhaseFailed,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			backup := &backupv1alpha1.Backup{
+				ObjectMeta: metav1.ObjectMeta{Name: "mysql-backup", Namespace: "db"},
+				Spec: backupv1alpha1.BackupSpec{
+					Schedule:        "0 3 * * *",
+					BackupClassName: "encrypted",
+					Input:           backupv1alpha1.Module{Type: "mysql"},
+					StorageLocation: &backupv1alpha1.StorageLocationReference{Name: "s3"},
+				},
+			}
+			run := &backupv1alpha1.BackupRun{
+				ObjectMeta: metav1.ObjectMeta{Name: "manual", Namespace: "db"},
+				Spec:       backupv1alpha1.BackupRunSpec{BackupName: backup.Name},
+			}
+			scheme := testScheme(t)
+			r := &BackupRunReconciler{
+				Client: fake.NewFakeClientWithScheme(scheme, append(test.objects, backup, run)...),
+				Log:    ctrl.Log.WithName("test"),
+				Scheme: scheme,
+			}
+
+			if err := r.reconcile(context.Background(), run); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if run.Status.Phase != test.phase {
+				t.Fatalf("expected phase %q, got %q: %s", test.phase, run.Status.Phase, run.Status.Reason)
+			}
+			if test.phase == backupv1alpha1.RunPhaseFailed {
+				if run.Status.Reason == "" {
+					t.Errorf("failed run has no reason")
+				}
+				return
+			}
+
+			job := &v1.Job{}
+			if err := r.Get(context.Background(), types.NamespacedName{Name: run.Name, Namespace: run.Namespace}, job); err != nil {
+				t.Fatalf("can't get backup job: %v", err)
+			}
+			env := containerEnv(job)
+			for key, value := range test.env {
+				if env[key] != value {
+					t.Errorf("expected %s=%q, got %q", key, value, env[key])
+				}
+			}
+		})
+	}
+}
+
+func TestBackupRunReconcile(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	finished := metav1.NewTime(time.Now().Truncate(time.Second))
+	backup := &backupv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysql-backup", Namespace: "db"},
+		Spec: backupv1alpha1.BackupSpec{
+			Schedule: "0 3 * * *",
+			Input:    backupv1alpha1.Module{Type: "mysql"},
+			Output:   backupv1alpha1.Module{Type: "s3"},
+		},
+	}
+	job := func(owner string, conditions ...v1.JobCondition) *v1.Job {
+		job := &v1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "manual", Namespace: "db"},
+			Status: v1.JobStatus{
+				StartTime:  &started,
+				Conditions: conditions,
+			},
+		}
+		if owner != "" {
+			controller := true
+			job.OwnerReferences = []metav1.OwnerReference{{
+				APIVersion: backupv1alpha1.GroupVersion.String(),
+				Kind:       "BackupRun",
+				Name:       "manual",
+				UID:        types.UID(owner),
+				Controller: &controller,
+			}}
+		}
+		return job
+	}
+
+	tests := map[string]struct {
+		backupName string
+		jobName    string
+		objects    []runtime.Object
+		expected   backupv1alpha1.BackupRunStatus
+	}{
+		"job created": {
+			objects: []runtime.Object{backup},
+			expected: backupv1alpha1.BackupRunStatus{
+				Phase:   backupv1alpha1.RunPhasePending,
+				JobName: "manual",
+			},
+		},
+		"backup not found": {
+			backupName: "missing",
+			expected: backupv1alpha1.BackupRunStatus{
+				Phase:  backupv1alpha1.RunPhaseFailed,
+				Reason: `backup "missing" not found`,
+			},
+		},
+		"job of another owner": {
+			objects: []runtime.Object{backup, job("other-uid")},
+			expected: backupv1alpha1.BackupRunStatus{
+				Phase:  backupv1alpha1.RunPhaseFailed,
+				Reason: `job "manual" already exists`,
+			},
+		},
+		"job created before": {
+			objects: []runtime.Object{backup, job("run-uid")},
+			expected: backupv1alpha1.BackupRunStatus{
+				Phase:     backupv1alpha1.RunPhaseRunning,
+				JobName:   "manual",
+				StartTime: &started,
+			},
+		},
+		"job missing": {
+			jobName: "manual",
+			objects: []runtime.Object{backup},
+			expected: backupv1alpha1.BackupRunStatus{
+				Phase:   backupv1alpha1.RunPhaseFailed,
+				JobName: "manual",
+				Reason:  `backup job "manual" not found`,
+			},
+		},
+		"job succeeded": {
+			jobName: "manual",
+			objects: []runtime.Object{backup, func() *v1.Job {
+				job := job("run-uid", v1.JobCondition{Type: v1.JobComplete, Status: corev1.ConditionTrue})
+				job.Status.CompletionTime = &finished
+				return job
+			}()},
+			expected: backupv1alpha1.BackupRunStatus{
+				Phase:      backupv1alpha1.RunPhaseSucceeded,
+				JobName:    "manual",
+				StartTime:  &started,
+				FinishTime: &finished,
+			},
+		},
+		"job failed": {
+			jobName: "manual",
+			objects: []runtime.Object{backup, job("run-uid", v1.JobCondition{
+				Type:               v1.JobFailed,
+				Status:             corev1.ConditionTrue,
+				Reason:             "BackoffLimitExceeded",
+				LastTransitionTime: finished,
+			})},
+			expected: backupv1alpha1.BackupRunStatus{
+				Phase:      backupv1alpha1.RunPhaseFailed,
+				JobName:    "manual",
+				StartTime:  &started,
+				FinishTime: &finished,
+				Reason:     "BackoffLimitExceeded",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			backupName := test.backupName
+			if backupName == "" {
+				backupName = backup.Name
+			}
+			run := &backupv1alpha1.BackupRun{
+				ObjectMeta: metav1.ObjectMeta{Name: "manual", Namespace: "db", UID: "run-uid"},
+				Spec:       backupv1alpha1.BackupRunSpec{BackupName: backupName},
+				Status:     backupv1alpha1.BackupRunStatus{JobName: test.jobName},
+			}
+			scheme := testScheme(t)
+			r := &BackupRunReconciler{
+				Client: fake.NewFakeClientWithScheme(scheme, append(test.objects, run)...),
+				Log:    ctrl.Log.WithName("test"),
+				Scheme: scheme,
+			}
+
+			if err := r.reconcile(context.Background(), run); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !equality.Semantic.DeepEqual(run.Status, test.expected) {
+				t.Errorf("unexpected status: %s", diff.ObjectReflectDiff(test.expected, run.Status))
+			}
+		})
+	}
+}
+
+func TestBackupRunReconcileSkipsFinishedRuns(t *testing.T) {
+	run := &backupv1alpha1.BackupRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "manual", Namespace: "db"},
+		Spec:       backupv1alpha1.BackupRunSpec{BackupName: "removed"},
+		Status: backupv1alpha1.BackupRunStatus{
+			Phase:   backupv1alpha1.RunPhaseSucceeded,
+			JobName: "manual",
+		},
+	}
+	scheme := testScheme(t)
+	r := &BackupRunReconciler{
+		Client: fake.NewFakeClientWithScheme(scheme, run),
+		Log:    ctrl.Log.WithName("test"),
+		Scheme: scheme,
+	}
+
+	key := types.NamespacedName{Name: run.Name, Namespace: run.Namespace}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored := &backupv1alpha1.BackupRun{}
+	if err := r.Get(context.Background(), key, stored); err != nil {
+		t.Fatalf("can't get run: %v", err)
+	}
+	if !equality.Semantic.DeepEqual(stored.Status, run.Status) {
+		t.Errorf("finished run is reconciled again: %s", diff.ObjectReflectDiff(run.Status, stored.Status))
+	}
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// storage location is revalidated periodically
+	// since referenced secrets may change at any time
+	storageLocationResyncPeriod = 5 * time.Minute
+	// Backup secrets are checked periodically for the same reason
+	secretsResyncPeriod = 5 * time.Minute
+)
+
+// BackupStorageLocationReconciler reconciles a BackupStorageLocation object
+type BackupStorageLocationReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// APIReader reads Secrets referenced by the output module directly
+	// from the API server, so the controller doesn't cache all Secrets
+	APIReader client.Reader
+}
+
+// +kubebuilder:rbac:groups=copybird.org,resources=backupstoragelocations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=copybird.org,resources=backupstoragelocations/status,verbs=get;update;patch
+
+// Reconcile implements controller reconcilation logic
+func (r *BackupStorageLocationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("backupstoragelocation", req.NamespacedName)
+
+	location := &backupv1alpha1.BackupStorageLocation{}
+	result := ctrl.Result{
+		RequeueAfter: storageLocationResyncPeriod,
+	}
+
+	if err := r.Get(ctx, req.NamespacedName, location); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("Object not in the queue", "object", req.NamespacedName)
+		} else {
+			log.Error(err, "Failed to get runtime object from request")
+		}
+		return ctrl.Result{}, nil
+	}
+
+	status := location.Status.DeepCopy()
+	condition, err := validateStorageLocation(ctx, r.APIReader, location.Namespace, location.Spec)
+	if err != nil {
+		log.Info("reconcilation error", "reason", err)
+		return ctrl.Result{Requeue: true}, err
+	}
+	condition.ObservedGeneration = location.Generation
+	backupv1alpha1.SetCondition(&status.Conditions, condition)
+	status.ObservedGeneration = location.Generation
+	if equality.Semantic.DeepEqual(status, &location.Status) {
+		return result, nil
+	}
+
+	err = patchStatus(ctx, r.Client, location, func() {
+		location.Status = *status
+	})
+	if err != nil {
+		log.Info("failed to update object status", "reason", err)
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	return result, nil
+}
+
+func (r *BackupStorageLocationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&backupv1alpha1.BackupStorageLocation{}).
+		Complete(r)
+}
+
+// ClusterBackupStorageLocationReconciler reconciles a ClusterBackupStorageLocation object
+type ClusterBackupStorageLocationReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=copybird.org,resources=clusterbackupstoragelocations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=copybird.org,resources=clusterbackupstoragelocations/status,verbs=get;update;patch
+
+// Reconcile implements controller reconcilation logic
+func (r *ClusterBackupStorageLocationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("clusterbackupstoragelocation", req.NamespacedName)
+
+	location := &backupv1alpha1.ClusterBackupStorageLocation{}
+	result := ctrl.Result{
+		RequeueAfter: storageLocationResyncPeriod,
+	}
+
+	if err := r.Get(ctx, req.NamespacedName, location); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("Object not in the queue", "object", req.NamespacedName)
+		} else {
+			log.Error(err, "Failed to get runtime object from request")
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// secrets of cluster-scoped location are resolved in each Backup
+	// namespace, so only the module itself can be validated here
+	status := location.Status.DeepCopy()
+	condition, err := validateStorageLocation(ctx, r.Client, "", location.Spec)
+	if err != nil {
+		log.Info("reconcilation error", "reason", err)
+		return ctrl.Result{Requeue: true}, err
+	}
+	condition.ObservedGeneration = location.Generation
+	backupv1alpha1.SetCondition(&status.Conditions, condition)
+	status.ObservedGeneration = location.Generation
+	if equality.Semantic.DeepEqual(status, &location.Status) {
+		return result, nil
+	}
+
+	err = patchStatus(ctx, r.Client, location, func() {
+		location.Status = *status
+	})
+	if err != nil {
+		log.Info("failed to update object status", "reason", err)
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	return result, nil
+}
+
+func (r *ClusterBackupStorageLocationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&backupv1alpha1.ClusterBackupStorageLocation{}).
+		Complete(r)
+}
+
+// validateStorageLocation returns Available condition of the storage location.
+// Module secrets are checked only if namespace is not empty.
+func validateStorageLocation(ctx context.Context, c client.Reader, namespace string, spec backupv1alpha1.BackupStorageLocationSpec) (backupv1alpha1.Condition, error) {
+	condition := backupv1alpha1.Condition{
+		Type:   backupv1alpha1.ConditionAvailable,
+		Status: corev1.ConditionFalse,
+	}
+
+	if spec.Output.Type == "" {
+		condition.Reason = "InvalidModule"
+		condition.Message = "output module type is not defined"
+		return condition, nil
+	}
+	for i, secret := range spec.Output.Secrets {
+		if secret.SecretKeyRef == nil || secret.SecretKeyRef.Name == "" || secret.SecretKeyRef.Key == "" {
+			condition.Reason = "InvalidModule"
+			condition.Message = fmt.Sprintf("output module secret %d must have secretKeyRef name and key", i)
+			return condition, nil
+		}
+	}
+
+	if namespace != "" {
+		reason, message, err := checkModuleSecrets(ctx, c, namespace, spec.Output)
+		if err != nil {
+			return condition, err
+		}
+		if reason != "" {
+			condition.Reason = reason
+			condition.Message = message
+			return condition, nil
+		}
+	}
+
+	condition.Status = corev1.ConditionTrue
+	condition.Reason = "Validated"
+	condition.Message = ""
+	return condition, nil
+}
+
+// checkModuleSecrets verifies that secret keys referenced by the module exist.
+// It returns a reason and a message if some of them don't.
+func checkModuleSecrets(ctx context.Context, c client.Reader, namespace string, module backupv1alpha1.Module) (string, string, error) {
+	for _, moduleSecret := range module.Secrets {
+		ref := moduleSecret.SecretKeyRef
+		if ref == nil || (ref.Optional != nil && *ref.Optional) {
+			continue
+		}
+		secret := &corev1.Secret{}
+		err := c.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, secret)
+		if apierrors.IsNotFound(err) {
+			return "SecretNotFound", fmt.Sprintf("secret %q not found", ref.Name), nil
+		} else if err != nil {
+			return "", "", err
+		}
+		if _, ok := secret.Data[ref.Key]; !ok {
+			return "SecretKeyNotFound", fmt.Sprintf("secret %q has no key %q", ref.Name, ref.Key), nil
+		}
+	}
+	return "", "", nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	cronschedule "github.com/copybird/copybird-crd/pkg/schedule"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// activeBlackout returns the blackout window of the Backup open at the
+// time, the one closing the latest if several windows are open
+func activeBlackout(backup *backupv1alpha1.Backup, now time.Time) (*backupv1alpha1.BlackoutStatus, error) {
+	var active *backupv1alpha1.BlackoutStatus
+	for _, window := range backup.Spec.BlackoutWindows {
+		start, err := parseCron(window.Start, backup.Spec.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("blackout window %q: %v", window.Name, err)
+		}
+		begin, end, ok := cronschedule.ActiveWindow(start, window.Duration.Duration, now)
+		if !ok || (active != nil && !end.After(active.End.Time)) {
+			continue
+		}
+		policy := window.Policy
+		if policy == "" {
+			policy = backupv1alpha1.BlackoutPolicySkip
+		}
+		active = &backupv1alpha1.BlackoutStatus{
+			Window: window.Name,
+			Policy: policy,
+			Start:  metav1.NewTime(begin),
+			End:    metav1.NewTime(end),
+		}
+	}
+	return active, nil
+}
+
+// recordBlackout records runs of the named schedules skipped by blackout
+// windows in the Backup status. It returns the end of the Skip window closed
+// since the previous reconcilation, runs missed before which must not be
+// caught up by CronJobs.
+func recordBlackout(backup, resolved *backupv1alpha1.Backup, names []string, blackout *backupv1alpha1.BlackoutStatus, now time.Time) *metav1.Time {
+	var skipUntil *metav1.Time
+	if previous := backup.Status.Blackout; previous != nil && !sameBlackout(previous, blackout) {
+		// the window may be closed early if it was removed from the spec
+		end := previous.End.Time
+		if now.Before(end) {
+			end = now
+		}
+		recordSkippedRuns(backup, resolved, names, previous, end)
+		if previous.Policy != backupv1alpha1.BlackoutPolicyDefer {
+			closed := metav1.NewTime(end)
+			skipUntil = &closed
+		}
+	}
+	if blackout != nil {
+		recordSkippedRuns(backup, resolved, names, blackout, now)
+	}
+	return skipUntil
+}
+
+// recordSkippedRuns records runs of the named schedules within the window until the time
+func recordSkippedRuns(backup, resolved *backupv1alpha1.Backup, names []string, window *backupv1alpha1.BlackoutStatus, until time.Time) {
+	for _, name := range names {
+		_, schedule, err := parseSchedule(scheduleBackup(resolved, name), name)
+		if err != nil {
+			// invalid schedule is reported by reconcileSchedule
+			continue
+		}
+		for _, run := range cronschedule.Runs(schedule, window.Start.Time, until) {
+			recordSkippedRun(&backup.Status, backupv1alpha1.SkippedRun{
+				Schedule:      name,
+				ScheduledTime: metav1.NewTime(run),
+				Window:        window.Window,
+			})
+		}
+	}
+}
+
+// nextBlackoutChange returns the time the next blackout window of the
+// Backup opens at or the open one closes at, whichever is earlier
+func nextBlackoutChange(backup *backupv1alpha1.Backup, now time.Time) (time.Time, bool) {
+	var change time.Time
+	if blackout := backup.Status.Blackout; blackout != nil {
+		change = blackout.End.Time
+	}
+	for _, window := range backup.Spec.BlackoutWindows {
+		start, err := parseCron(window.Start, backup.Spec.TimeZone)
+		if err != nil {
+			continue
+		}
+		if next := start.Next(now); !next.IsZero() && (change.IsZero() || next.Before(change)) {
+			change = next
+		}
+	}
+	return change, !change.IsZero()
+}
+
+func sameBlackout(a, b *backupv1alpha1.BlackoutStatus) bool {
+	return a != nil && b != nil && a.Window == b.Window && a.Start.Equal(&b.Start)
+}
@@ -0,0 +1,311 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"github.com/copybird/copybird-crd/controllers/resources"
+	"github.com/robfig/cron/v3"
+	"k8s.io/api/batch/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+// blackoutBackup returns a Backup with the month-end and nightly blackout windows
+func blackoutBackup() *backupv1alpha1.Backup {
+	return &backupv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysql-backup", Namespace: "db"},
+		Spec: backupv1alpha1.BackupSpec{
+			Schedule:  "0 * * * *",
+			Schedules: []backupv1alpha1.BackupSchedule{{Name: "daily", Schedule: "30 2 * * *"}},
+			Input:     backupv1alpha1.Module{Type: "mysql"},
+			Output:    backupv1alpha1.Module{Type: "s3"},
+			BlackoutWindows: []backupv1alpha1.BlackoutWindow{
+				{Name: "month-end", Start: "0 0 31 * *", Duration: metav1.Duration{Duration: 3 * time.Hour}},
+				{Name: "nightly", Start: "0 0 * * *", Duration: metav1.Duration{Duration: 2 * time.Hour},
+					Policy: backupv1alpha1.BlackoutPolicyDefer},
+			},
+		},
+	}
+}
+
+func TestActiveBlackout(t *testing.T) {
+	date := func(day, hour, minute int) time.Time {
+		return time.Date(2019, time.October, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := map[string]struct {
+		mutate   func(backup *backupv1alpha1.Backup)
+		now      time.Time
+		expected *backupv1alpha1.BlackoutStatus
+	}{
+		"no window open": {
+			now: date(30, 12, 0),
+		},
+		"window open": {
+			now: date(30, 1, 30),
+			expected: &backupv1alpha1.BlackoutStatus{
+				Window: "nightly",
+				Policy: backupv1alpha1.BlackoutPolicyDefer,
+				Start:  metav1.NewTime(date(30, 0, 0)),
+				End:    metav1.NewTime(date(30, 2, 0)),
+			},
+		},
+		"window closing the latest": {
+			now: date(31, 1, 30),
+			expected: &backupv1alpha1.BlackoutStatus{
+				Window: "month-end",
+				Policy: backupv1alpha1.BlackoutPolicySkip,
+				Start:  metav1.NewTime(date(31, 0, 0)),
+				End:    metav1.NewTime(date(31, 3, 0)),
+			},
+		},
+		"window in the time zone": {
+			mutate: func(backup *backupv1alpha1.Backup) { backup.Spec.TimeZone = "Asia/Bishkek" },
+			now:    date(29, 19, 30),
+			expected: &backupv1alpha1.BlackoutStatus{
+				Window: "nightly",
+				Policy: backupv1alpha1.BlackoutPolicyDefer,
+				Start:  metav1.NewTime(date(29, 18, 0)),
+				End:    metav1.NewTime(date(29, 20, 0)),
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			backup := blackoutBackup()
+			if test.mutate != nil {
+				test.mutate(backup)
+			}
+			blackout, err := activeBlackout(backup, test.now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !equality.Semantic.DeepEqual(blackout, test.expected) {
+				t.Errorf("unexpected blackout: %s", diff.ObjectReflectDiff(test.expected, blackout))
+			}
+		})
+	}
+
+	backup := blackoutBackup()
+	backup.Spec.BlackoutWindows[0].Start = "bad"
+	if _, err := activeBlackout(backup, date(30, 12, 0)); err == nil {
+		t.Errorf("no error for an invalid window")
+	}
+}
+
+func TestRecordBlackout(t *testing.T) {
+	date := func(hour, minute int) time.Time {
+		return time.Date(2019, time.October, 31, hour, minute, 0, 0, time.UTC)
+	}
+	monthEnd := func(policy backupv1alpha1.BlackoutPolicy) *backupv1alpha1.BlackoutStatus {
+		return &backupv1alpha1.BlackoutStatus{
+			Window: "month-end",
+			Policy: policy,
+			Start:  metav1.NewTime(date(0, 0)),
+			End:    metav1.NewTime(date(3, 0)),
+		}
+	}
+	skipped := func(schedule string, hour, minute int) backupv1alpha1.SkippedRun {
+		return backupv1alpha1.SkippedRun{
+			Schedule:      schedule,
+			ScheduledTime: metav1.NewTime(date(hour, minute)),
+			Window:        "month-end",
+		}
+	}
+
+	tests := map[string]struct {
+		previous, blackout *backupv1alpha1.BlackoutStatus
+		now                time.Time
+		skipUntil          *metav1.Time
+		// skipped runs are the latest first
+		skipped []backupv1alpha1.SkippedRun
+	}{
+		"no window": {
+			now: date(1, 30),
+		},
+		"window opened": {
+			blackout: monthEnd(backupv1alpha1.BlackoutPolicySkip),
+			now:      date(1, 30),
+			skipped:  []backupv1alpha1.SkippedRun{skipped("", 1, 0), skipped("", 0, 0)},
+		},
+		"window open": {
+			previous: monthEnd(backupv1alpha1.BlackoutPolicySkip),
+			blackout: monthEnd(backupv1alpha1.BlackoutPolicySkip),
+			now:      date(1, 30),
+			skipped:  []backupv1alpha1.SkippedRun{skipped("", 1, 0), skipped("", 0, 0)},
+		},
+		"window closed": {
+			previous:  monthEnd(backupv1alpha1.BlackoutPolicySkip),
+			now:       date(4, 30),
+			skipUntil: &metav1.Time{Time: date(3, 0)},
+			skipped: []backupv1alpha1.SkippedRun{skipped("daily", 2, 30), skipped("", 2, 0),
+				skipped("", 1, 0), skipped("", 0, 0)},
+		},
+		"window removed": {
+			previous:  monthEnd(backupv1alpha1.BlackoutPolicySkip),
+			now:       date(1, 30),
+			skipUntil: &metav1.Time{Time: date(1, 30)},
+			skipped:   []backupv1alpha1.SkippedRun{skipped("", 1, 0), skipped("", 0, 0)},
+		},
+		"deferred window closed": {
+			previous: monthEnd(backupv1alpha1.BlackoutPolicyDefer),
+			now:      date(4, 30),
+			skipped: []backupv1alpha1.SkippedRun{skipped("daily", 2, 30), skipped("", 2, 0),
+				skipped("", 1, 0), skipped("", 0, 0)},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			backup := blackoutBackup()
+			backup.Status.Blackout = test.previous
+			skipUntil := recordBlackout(backup, backup.DeepCopy(), []string{"", "daily"}, test.blackout, test.now)
+			if !equality.Semantic.DeepEqual(skipUntil, test.skipUntil) {
+				t.Errorf("expected skip until %v, got %v", test.skipUntil, skipUntil)
+			}
+			if !equality.Semantic.DeepEqual(backup.Status.SkippedRuns, test.skipped) {
+				t.Errorf("unexpected skipped runs: %s", diff.ObjectReflectDiff(test.skipped, backup.Status.SkippedRuns))
+			}
+		})
+	}
+}
+
+func TestSkipResumeTime(t *testing.T) {
+	date := func(hour, minute int) time.Time {
+		return time.Date(2019, time.October, 31, hour, minute, 0, 0, time.UTC)
+	}
+	schedule, err := cron.ParseStandard("0 * * * *")
+	if err != nil {
+		t.Fatalf("can't parse schedule: %v", err)
+	}
+	timePtr := func(t time.Time) *time.Time { return &t }
+
+	tests := map[string]struct {
+		created      bool
+		lastSchedule *time.Time
+		resumeAt     string
+		skipUntil    *metav1.Time
+		expected     *time.Time
+	}{
+		"no window closed": {
+			created: true,
+		},
+		"held CronJob": {
+			created:  true,
+			resumeAt: "2019-10-31T05:00:00Z",
+			expected: timePtr(date(5, 0)),
+		},
+		"resume time edited out of band": {
+			created:  true,
+			resumeAt: "tomorrow",
+		},
+		"new CronJob": {
+			skipUntil: &metav1.Time{Time: date(2, 30)},
+		},
+		"scheduled since the window closed": {
+			created:      true,
+			lastSchedule: timePtr(date(3, 0)),
+			skipUntil:    &metav1.Time{Time: date(2, 30)},
+		},
+		"runs to catch up": {
+			created:      true,
+			lastSchedule: timePtr(date(0, 0)),
+			skipUntil:    &metav1.Time{Time: date(2, 30)},
+			expected:     timePtr(date(3, 0)),
+		},
+		"later resume time kept": {
+			created:      true,
+			lastSchedule: timePtr(date(0, 0)),
+			resumeAt:     "2019-10-31T05:00:00Z",
+			skipUntil:    &metav1.Time{Time: date(2, 30)},
+			expected:     timePtr(date(5, 0)),
+		},
+		"earlier resume time moved": {
+			created:      true,
+			lastSchedule: timePtr(date(0, 0)),
+			resumeAt:     "2019-10-31T01:00:00Z",
+			skipUntil:    &metav1.Time{Time: date(2, 30)},
+			expected:     timePtr(date(3, 0)),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cronjob := &v1beta1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "mysql-backup", Namespace: "db"}}
+			if test.created {
+				cronjob.CreationTimestamp = metav1.NewTime(date(0, 0).Add(-24 * time.Hour))
+			}
+			if test.lastSchedule != nil {
+				cronjob.Status.LastScheduleTime = &metav1.Time{Time: *test.lastSchedule}
+			}
+			if test.resumeAt != "" {
+				cronjob.Annotations = map[string]string{resources.ResumeAtAnnotation: test.resumeAt}
+			}
+			resumeAt := skipResumeTime(cronjob, schedule, test.skipUntil)
+			if (resumeAt == nil) != (test.expected == nil) || (resumeAt != nil && !resumeAt.Equal(*test.expected)) {
+				t.Errorf("expected resume time %v, got %v", test.expected, resumeAt)
+			}
+		})
+	}
+}
+
+func TestNextBlackoutChange(t *testing.T) {
+	date := func(day, hour int) time.Time {
+		return time.Date(2019, time.October, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	tests := map[string]struct {
+		mutate   func(backup *backupv1alpha1.Backup)
+		expected time.Time
+	}{
+		"no windows": {
+			mutate: func(backup *backupv1alpha1.Backup) { backup.Spec.BlackoutWindows = nil },
+		},
+		"next window opens": {
+			expected: date(31, 0),
+		},
+		"open window closes": {
+			mutate: func(backup *backupv1alpha1.Backup) {
+				backup.Status.Blackout = &backupv1alpha1.BlackoutStatus{
+					Window: "month-end",
+					Start:  metav1.NewTime(date(30, 12)),
+					End:    metav1.NewTime(date(30, 15)),
+				}
+			},
+			expected: date(30, 15),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			backup := blackoutBackup()
+			if test.mutate != nil {
+				test.mutate(backup)
+			}
+			change, ok := nextBlackoutChange(backup, date(30, 13))
+			if ok != !test.expected.IsZero() || !change.Equal(test.expected) {
+				t.Errorf("expected change at %v, got %v (%v)", test.expected, change, ok)
+			}
+		})
+	}
+}
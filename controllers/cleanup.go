@@ -0,0 +1,227 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"github.com/copybird/copybird-crd/controllers/resources"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// cleanupRetryBackoff is the delay before the first retry of a failed
+	// cleanup, it doubles with every failed attempt
+	cleanupRetryBackoff = 30 * time.Second
+	// cleanupMaxRetryBackoff is the longest delay between cleanup attempts
+	cleanupMaxRetryBackoff = time.Hour
+)
+
+// cleanup removes artifacts of the deleted Backup from its outputs with
+// a cleanup Job per output. It returns true once all of them succeed.
+// Failed Jobs are deleted and started again with an exponential backoff,
+// so the Backup is kept until the cleanup succeeds or the deletion policy
+// is changed to Retain. The same applies to the Backup whose outputs can't
+// be resolved, it is cleaned up once its class or storage location is fixed.
+func (r *BackupReconciler) cleanup(ctx context.Context, backup *backupv1alpha1.Backup) (bool, error) {
+	log := r.Log.WithName("reconciler")
+
+	// no new artifacts must be created while they are removed
+	if err := r.deleteStaleCronJobs(ctx, backup, sets.NewString()); err != nil {
+		return false, err
+	}
+
+	status := &backupv1alpha1.CleanupStatus{Phase: backupv1alpha1.RunPhaseSucceeded}
+	fail := func(message string) {
+		status.Phase = backupv1alpha1.RunPhaseFailed
+		status.Message = message
+	}
+
+	resolved, err := resolveBackup(ctx, r.Client, backup)
+	if err != nil {
+		// retrying won't help until the class or storage location changes,
+		// which reconciles the Backup again
+		fail(fmt.Sprintf("can't resolve backup: %v", err))
+		return false, r.updateCleanupStatus(ctx, backup, status)
+	}
+
+	names := outputNames(resolved)
+	if output, scoped := unscopedOutput(resolved, names); !scoped {
+		// removing everything from the output would remove artifacts
+		// of other Backups too
+		fail(fmt.Sprintf("%s can't be scoped to the backup, set deletionPolicy to Retain", output))
+		return false, r.updateCleanupStatus(ctx, backup, status)
+	}
+
+	// failed attempts are counted across reconcilations, the Jobs
+	// deleted after a failure are started again once the backoff passes
+	now := time.Now()
+	waiting := false
+	if previous := backup.Status.Cleanup; previous != nil {
+		status.Failures = previous.Failures
+		if previous.NextRetryTime != nil && now.Before(previous.NextRetryTime.Time) {
+			waiting = true
+			status.NextRetryTime = previous.NextRetryTime
+			fail(previous.Message)
+		}
+	}
+
+	var failures []string
+	for _, name := range names {
+		scheduled := scheduleBackup(resolved, name)
+		jobName := resources.MakeJobName(resources.MakeCronJobName(backup.Name, name), resources.JobTypeCleanup)
+		status.Jobs = append(status.Jobs, jobName)
+
+		job := &v1.Job{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: backup.Namespace, Name: jobName}, job)
+		if apierrors.IsNotFound(err) {
+			if waiting {
+				continue
+			}
+			copybird := resources.NewCopyBirdParams(backupImage(log, scheduled), scheduled)
+			copybird.Schedule = name
+			job, err = copybird.MakeCleanupJob(ctx, jobName)
+			if err != nil {
+				// invalid pod template can't be fixed by retrying
+				fail(fmt.Sprintf("can't render cleanup job %s: %v", jobName, err))
+				continue
+			}
+			if err := controllerutil.SetControllerReference(backup, job, r.Scheme); err != nil {
+				return false, err
+			}
+			if err := r.Create(ctx, job); err != nil && !apierrors.IsAlreadyExists(err) {
+				return false, err
+			}
+			log.Info("Cleanup job created", "job", jobName)
+			r.Recorder.Eventf(backup, corev1.EventTypeNormal, "CleanupStarted", "Started cleanup job %s", jobName)
+		} else if err != nil {
+			return false, err
+		}
+
+		switch phase, reason := jobPhase(job); phase {
+		case backupv1alpha1.RunPhaseSucceeded:
+		case backupv1alpha1.RunPhaseFailed:
+			// the Job is started again after the backoff
+			err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+			if err != nil && !apierrors.IsNotFound(err) {
+				return false, err
+			}
+			failures = append(failures, fmt.Sprintf("cleanup job %s failed: %s", jobName, reason))
+		default:
+			if status.Phase != backupv1alpha1.RunPhaseFailed {
+				status.Phase = backupv1alpha1.RunPhaseRunning
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		status.Failures++
+		retry := metav1.NewTime(now.Add(cleanupBackoff(status.Failures)))
+		status.NextRetryTime = &retry
+		fail(fmt.Sprintf("%s, retrying at %s", strings.Join(failures, "; "), retry.UTC().Format(time.RFC3339)))
+	}
+
+	if err := r.updateCleanupStatus(ctx, backup, status); err != nil {
+		return false, err
+	}
+	return status.Phase == backupv1alpha1.RunPhaseSucceeded, nil
+}
+
+// cleanupBackoff returns the delay before the cleanup is retried after the
+// given number of failed attempts
+func cleanupBackoff(failures int32) time.Duration {
+	backoff := cleanupRetryBackoff
+	for i := int32(1); i < failures && backoff < cleanupMaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > cleanupMaxRetryBackoff {
+		backoff = cleanupMaxRetryBackoff
+	}
+	return backoff
+}
+
+// outputNames returns names of the schedules keeping artifacts in their own
+// output, the main Backup output has an empty name
+func outputNames(resolved *backupv1alpha1.Backup) []string {
+	names := []string{""}
+	for _, schedule := range resolved.Spec.Schedules {
+		if schedule.Output != nil {
+			names = append(names, schedule.Name)
+		}
+	}
+	return names
+}
+
+// unscopedOutput returns the description of the first named output whose
+// artifacts can't be told apart from the ones of other Backups
+func unscopedOutput(resolved *backupv1alpha1.Backup, names []string) (string, bool) {
+	for _, name := range names {
+		output := scheduleBackup(resolved, name).Spec.Output
+		if resources.OutputScoped(output) {
+			continue
+		}
+		if name == "" {
+			return fmt.Sprintf("output %q", output.Type), false
+		}
+		return fmt.Sprintf("output %q of schedule %s", output.Type, name), false
+	}
+	return "", true
+}
+
+// reconcileDeletionPolicy sets DeletionPolicyAccepted condition, refusing
+// Delete policy of the Backup whose artifacts can't be scoped to it
+func (r *BackupReconciler) reconcileDeletionPolicy(backup, resolved *backupv1alpha1.Backup) {
+	if resolved.Spec.DeletionPolicy != backupv1alpha1.DeletionPolicyDelete {
+		setBackupCondition(backup, backupv1alpha1.ConditionDeletionPolicyAccepted, corev1.ConditionTrue, "ArtifactsRetained", "")
+		return
+	}
+	output, scoped := unscopedOutput(resolved, outputNames(resolved))
+	if scoped {
+		setBackupCondition(backup, backupv1alpha1.ConditionDeletionPolicyAccepted, corev1.ConditionTrue, "ArtifactsScoped", "")
+		return
+	}
+	message := fmt.Sprintf("%s can't be scoped to the backup, its artifacts can't be deleted", output)
+	if backupConditionChanged(backup, backupv1alpha1.ConditionDeletionPolicyAccepted, corev1.ConditionFalse, "OutputNotScoped", message) {
+		r.Recorder.Event(backup, corev1.EventTypeWarning, "DeletionPolicyRefused", message)
+	}
+	setBackupCondition(backup, backupv1alpha1.ConditionDeletionPolicyAccepted, corev1.ConditionFalse, "OutputNotScoped", message)
+}
+
+// updateCleanupStatus saves the cleanup status of the Backup, reporting
+// the failure with an Event once
+func (r *BackupReconciler) updateCleanupStatus(ctx context.Context, backup *backupv1alpha1.Backup, status *backupv1alpha1.CleanupStatus) error {
+	if equality.Semantic.DeepEqual(backup.Status.Cleanup, status) {
+		return nil
+	}
+	if status.Phase == backupv1alpha1.RunPhaseFailed {
+		r.Recorder.Event(backup, corev1.EventTypeWarning, "CleanupFailed", status.Message)
+	}
+	return patchStatus(ctx, r.Client, backup, func() {
+		backup.Status.Cleanup = status
+	})
+}
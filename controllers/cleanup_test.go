@@ -0,0 +1,302 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"github.com/copybird/copybird-crd/controllers/resources"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+)
+
+// deletedBackup returns a deleted Backup removing its artifacts
+func deletedBackup() *backupv1alpha1.Backup {
+	now := metav1.Now()
+	return &backupv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "mysql-backup",
+			Namespace:         "db",
+			DeletionTimestamp: &now,
+			Finalizers:        []string{finalizerName},
+		},
+		Spec: backupv1alpha1.BackupSpec{
+			Schedule:       "0 3 * * *",
+			Input:          backupv1alpha1.Module{Type: "mysql"},
+			Output:         backupv1alpha1.Module{Type: "s3"},
+			DeletionPolicy: backupv1alpha1.DeletionPolicyDelete,
+		},
+	}
+}
+
+// finishJob sets the condition of the finished Job
+func finishJob(t *testing.T, r *BackupReconciler, key types.NamespacedName, condition v1.JobConditionType) {
+	job := &v1.Job{}
+	if err := r.Get(context.Background(), key, job); err != nil {
+		t.Fatalf("can't get job %s: %v", key, err)
+	}
+	job.Status.Conditions = append(job.Status.Conditions, v1.JobCondition{
+		Type:   condition,
+		Status: corev1.ConditionTrue,
+		Reason: "BackoffLimitExceeded",
+	})
+	if err := r.Update(context.Background(), job); err != nil {
+		t.Fatalf("can't update job %s: %v", key, err)
+	}
+}
+
+func TestCleanupRetriesFailedJobs(t *testing.T) {
+	ctx := context.Background()
+	backup := deletedBackup()
+	r := newTestBackupReconciler(t, backup)
+	key := types.NamespacedName{
+		Name:      resources.MakeJobName(backup.Name, resources.JobTypeCleanup),
+		Namespace: backup.Namespace,
+	}
+	finalize := func() bool {
+		t.Helper()
+		finalized, err := r.finalize(ctx, backup)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return finalized
+	}
+	jobExists := func() bool {
+		t.Helper()
+		err := r.Get(ctx, key, &v1.Job{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			t.Fatalf("can't get job: %v", err)
+		}
+		return err == nil
+	}
+
+	if finalize() || !jobExists() {
+		t.Fatalf("cleanup job isn't started")
+	}
+
+	finishJob(t, r, key, v1.JobFailed)
+	if finalize() {
+		t.Fatalf("backup is finalized after a failed cleanup")
+	}
+	cleanup := backup.Status.Cleanup
+	if cleanup.Phase != backupv1alpha1.RunPhaseFailed || cleanup.Failures != 1 || cleanup.NextRetryTime == nil {
+		t.Fatalf("failure isn't recorded: %+v", cleanup)
+	}
+	if jobExists() {
+		t.Errorf("failed cleanup job isn't deleted")
+	}
+
+	// the Job isn't started again until the backoff passes
+	if finalize() || jobExists() {
+		t.Fatalf("cleanup job is started again before the backoff passes")
+	}
+	retry := metav1.NewTime(time.Now().Add(-time.Second))
+	backup.Status.Cleanup.NextRetryTime = &retry
+	if err := r.Update(ctx, backup); err != nil {
+		t.Fatalf("can't update backup: %v", err)
+	}
+	if finalize() || !jobExists() {
+		t.Fatalf("cleanup job isn't started again after the backoff")
+	}
+	if backup.Status.Cleanup.Failures != 1 {
+		t.Errorf("failures aren't kept across attempts: %+v", backup.Status.Cleanup)
+	}
+
+	finishJob(t, r, key, v1.JobComplete)
+	if !finalize() {
+		t.Errorf("backup isn't finalized after a successful cleanup")
+	}
+}
+
+func TestCleanupBackoff(t *testing.T) {
+	tests := map[int32]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		4:  4 * time.Minute,
+		8:  time.Hour,
+		40: time.Hour,
+	}
+	for failures, expected := range tests {
+		if backoff := cleanupBackoff(failures); backoff != expected {
+			t.Errorf("%d failures: expected %v, got %v", failures, expected, backoff)
+		}
+	}
+}
+
+func TestFinalizeRetainsArtifacts(t *testing.T) {
+	ctx := context.Background()
+	backup := deletedBackup()
+	backup.Spec.DeletionPolicy = backupv1alpha1.DeletionPolicyRetain
+	r := newTestBackupReconciler(t, backup)
+
+	finalized, err := r.finalize(ctx, backup)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !finalized {
+		t.Errorf("backup retaining its artifacts isn't finalized")
+	}
+	if jobs := jobNames(t, r); jobs.Len() != 0 {
+		t.Errorf("cleanup jobs are started: %v", jobs.List())
+	}
+	events := r.Recorder.(*record.FakeRecorder).Events
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %d", len(events))
+	}
+	if event := <-events; !strings.Contains(event, "artifacts retained") {
+		t.Errorf("unexpected event: %s", event)
+	}
+}
+
+func TestCleanupStartsJobPerOutput(t *testing.T) {
+	ctx := context.Background()
+	backup := deletedBackup()
+	backup.Spec.Schedules = []backupv1alpha1.BackupSchedule{
+		{Name: "hourly", Schedule: "0 * * * *", Output: &backupv1alpha1.Module{
+			Params: []backupv1alpha1.ModuleParam{{Key: "bucket", Value: "hourly"}},
+		}},
+		{Name: "weekly", Schedule: "0 3 * * 0"},
+	}
+	r := newTestBackupReconciler(t, backup)
+
+	finalized, err := r.finalize(ctx, backup)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if finalized {
+		t.Fatalf("backup is finalized before the cleanup finishes")
+	}
+
+	scopes := map[string]string{
+		resources.MakeJobName(resources.MakeCronJobName(backup.Name, ""), resources.JobTypeCleanup):       "db/mysql-backup",
+		resources.MakeJobName(resources.MakeCronJobName(backup.Name, "hourly"), resources.JobTypeCleanup): "db/mysql-backup/hourly",
+	}
+	if jobs := jobNames(t, r); !jobs.Equal(sets.StringKeySet(scopes)) {
+		t.Fatalf("expected cleanup jobs %v, got %v", sets.StringKeySet(scopes).List(), jobs.List())
+	}
+	for name, scope := range scopes {
+		job := &v1.Job{}
+		if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: backup.Namespace}, job); err != nil {
+			t.Fatalf("can't get job %s: %v", name, err)
+		}
+		if env := containerEnv(job); env["COPYBIRD_SCOPE"] != scope {
+			t.Errorf("job %s: expected scope %q, got %q", name, scope, env["COPYBIRD_SCOPE"])
+		}
+	}
+	cleanup := backup.Status.Cleanup
+	if cleanup == nil || cleanup.Phase != backupv1alpha1.RunPhaseRunning || len(cleanup.Jobs) != len(scopes) {
+		t.Errorf("unexpected cleanup status: %+v", cleanup)
+	}
+}
+
+func TestCleanupRefusesUnscopedOutputs(t *testing.T) {
+	tests := map[string]func(backup *backupv1alpha1.Backup){
+		"backup output": func(backup *backupv1alpha1.Backup) {
+			backup.Spec.Output = backupv1alpha1.Module{Type: "http"}
+		},
+		"schedule output": func(backup *backupv1alpha1.Backup) {
+			backup.Spec.Schedules = []backupv1alpha1.BackupSchedule{
+				{Name: "hourly", Schedule: "0 * * * *", Output: &backupv1alpha1.Module{Type: "http"}},
+			}
+		},
+	}
+
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			backup := deletedBackup()
+			mutate(backup)
+			r := newTestBackupReconciler(t, backup)
+
+			finalized, err := r.finalize(ctx, backup)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if finalized {
+				t.Errorf("backup with an unscoped output is finalized")
+			}
+			if jobs := jobNames(t, r); jobs.Len() != 0 {
+				t.Errorf("cleanup jobs are started: %v", jobs.List())
+			}
+			cleanup := backup.Status.Cleanup
+			if cleanup == nil || cleanup.Phase != backupv1alpha1.RunPhaseFailed ||
+				!strings.Contains(cleanup.Message, "set deletionPolicy to Retain") {
+				t.Errorf("refusal isn't recorded: %+v", cleanup)
+			}
+		})
+	}
+}
+
+func TestReconcileDeletionPolicy(t *testing.T) {
+	tests := map[string]struct {
+		policy backupv1alpha1.DeletionPolicy
+		output string
+		status corev1.ConditionStatus
+		reason string
+	}{
+		"retain": {
+			policy: backupv1alpha1.DeletionPolicyRetain,
+			output: "http",
+			status: corev1.ConditionTrue,
+			reason: "ArtifactsRetained",
+		},
+		"delete scoped artifacts": {
+			policy: backupv1alpha1.DeletionPolicyDelete,
+			output: "s3",
+			status: corev1.ConditionTrue,
+			reason: "ArtifactsScoped",
+		},
+		"delete unscoped artifacts": {
+			policy: backupv1alpha1.DeletionPolicyDelete,
+			output: "http",
+			status: corev1.ConditionFalse,
+			reason: "OutputNotScoped",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			backup := deletedBackup()
+			backup.DeletionTimestamp = nil
+			backup.Spec.DeletionPolicy = test.policy
+			backup.Spec.Output.Type = test.output
+			r := newTestBackupReconciler(t)
+
+			// the refusal is reported once
+			r.reconcileDeletionPolicy(backup, backup.DeepCopy())
+			r.reconcileDeletionPolicy(backup, backup.DeepCopy())
+
+			condition := backupv1alpha1.FindCondition(backup.Status.Conditions, backupv1alpha1.ConditionDeletionPolicyAccepted)
+			if condition == nil || condition.Status != test.status || condition.Reason != test.reason {
+				t.Fatalf("expected condition %s/%s, got %+v", test.status, test.reason, condition)
+			}
+			events := len(r.Recorder.(*record.FakeRecorder).Events)
+			if refused := test.status == corev1.ConditionFalse; (events == 1) != refused || events > 1 {
+				t.Errorf("expected refusal event %v, got %d events", refused, events)
+			}
+		})
+	}
+}
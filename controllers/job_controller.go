@@ -18,18 +18,23 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
+	"strings"
 
 	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"github.com/copybird/copybird-crd/controllers/resources"
 	"github.com/go-logr/logr"
 	v1 "k8s.io/api/batch/v1"
 	"k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 const (
@@ -40,8 +45,18 @@ type JobReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// Recorder reports backup runs with Events on their Backup
+	Recorder record.EventRecorder
+	// APIReader reads pods of finished Jobs directly from the API server,
+	// so the controller doesn't cache every pod of the cluster
+	APIReader client.Reader
 }
 
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
 // Reconcile implements controller reconcilation logic
 func (r *JobReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -61,82 +76,338 @@ func (r *JobReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return result, nil
 	}
 
-	accessor, err := meta.Accessor(job)
+	backup, err := r.getBackup(ctx, job)
 	if err != nil {
-		log.Error(err, "Failed to get metadata accessor")
+		log.Info("can't get job backup", "reason", err)
+		result.Requeue = true
 		return result, err
 	}
+	if backup == nil {
+		return result, nil
+	}
+
+	if job.Labels[resources.JobTypeLabel] == resources.JobTypePrune {
+		if err := r.reconcilePrune(ctx, backup, job); err != nil {
+			log.Info("can't reconcile prune job", "reason", err)
+			result.Requeue = true
+			return result, err
+		}
+		return result, nil
+	}
+
+	// cleanup progress is reported by BackupReconciler
+	if job.Labels[resources.JobTypeLabel] == resources.JobTypeCleanup {
+		return result, nil
+	}
+
+	phase, reason := jobPhase(job)
+	currentStatus := backupv1alpha1.JobStatus{
+		Name:       job.Name,
+		Success:    phase == backupv1alpha1.RunPhaseSucceeded,
+		StartTime:  job.Status.StartTime,
+		FinishTime: jobFinishTime(job),
+		Schedule:   job.Labels[resources.ScheduleLabel],
+	}
+
+	// the result is read from the pod once, since the pod may be gone later
+	previous := findJobStatus(backup.Status.Jobs, job.Name)
+	if currentStatus.Success {
+		if previous != nil && previous.Result != nil {
+			currentStatus.Result = previous.Result
+		} else if currentStatus.Result, err = r.runResult(ctx, job); err != nil {
+			log.Info("can't get backup job result", "reason", err)
+			result.Requeue = true
+			return result, err
+		}
+	}
+
+	// events are recorded only once the run starts and finishes
+	seen := previous != nil
+	finished := recordJobStatus(backup.Status.DeepCopy(), currentStatus)
+
+	// prune Job is created before the status is saved, otherwise
+	// a failed status update would make it skipped on retry
+	if finished && currentStatus.Success {
+		if err := r.createPruneJob(ctx, backup, job, currentStatus.Schedule); err != nil {
+			log.Info("can't create prune job", "reason", err)
+			result.Requeue = true
+			return result, err
+		}
+	}
+
+	err = patchStatus(ctx, r.Client, backup, func() {
+		if recordJobStatus(&backup.Status, currentStatus) {
+			observeFinishedRun(backup, currentStatus, reason)
+		}
+	})
+	if err != nil {
+		log.Info("can't update backup status", "reason", err)
+		result.Requeue = true
+		return result, err
+	}
+
+	if finished {
+		size := int64(-1)
+		if currentStatus.Result != nil && currentStatus.Result.BytesOut != nil {
+			size = *currentStatus.Result.BytesOut
+		}
+		observeRunMetrics(backup, currentStatus, size)
+	}
+	switch {
+	case finished && currentStatus.Success:
+		r.Recorder.Eventf(backup, corev1.EventTypeNormal, "RunSucceeded", "Job %s succeeded", job.Name)
+	case finished:
+		r.Recorder.Eventf(backup, corev1.EventTypeWarning, "RunFailed", "Job %s failed: %s", job.Name, reason)
+	case !seen && currentStatus.FinishTime == nil:
+		r.Recorder.Eventf(backup, corev1.EventTypeNormal, "RunStarted", "Job %s started", job.Name)
+	}
+
+	return result, nil
+}
+
+// createPruneJob starts retention policy enforcement after a successful
+// backup Job. Artifacts of additional schedules are pruned in the schedule
+// output by the schedule retention policy.
+func (r *JobReconciler) createPruneJob(ctx context.Context, backup *backupv1alpha1.Backup, job *v1.Job, schedule string) error {
+	resolved, err := resolveBackup(ctx, r.Client, backup)
+	if err != nil {
+		return err
+	}
+	// the schedule may have been removed since the Job had started
+	scheduled := scheduleBackup(resolved, schedule)
+	if scheduled == nil || scheduled.Spec.Retention == nil {
+		return nil
+	}
+
+	// only artifacts of the schedule are pruned, the ones of other
+	// schedules and Backups sharing the output are kept
+	copybird := resources.NewCopyBirdParams(backupImage(r.Log, scheduled), scheduled)
+	copybird.Schedule = schedule
+	pruneJob, err := copybird.MakePruneJob(ctx, resources.MakeJobName(job.Name, resources.JobTypePrune))
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(backup, pruneJob, r.Scheme); err != nil {
+		return err
+	}
+	if err := r.Create(ctx, pruneJob); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	r.Log.Info("Prune job created", "job", pruneJob.Name)
+	return nil
+}
+
+// reconcilePrune reflects prune Job outcome in the Backup status
+func (r *JobReconciler) reconcilePrune(ctx context.Context, backup *backupv1alpha1.Backup, job *v1.Job) error {
+	phase, _ := jobPhase(job)
+	if backup.Status.Prune != nil && backup.Status.Prune.JobName == job.Name && backup.Status.Prune.Phase == phase {
+		return nil
+	}
+
+	var pruned []string
+	if phase == backupv1alpha1.RunPhaseSucceeded {
+		message, err := r.terminationMessage(ctx, job)
+		if err != nil {
+			return err
+		}
+		pruneResult := struct {
+			Pruned []string `json:"pruned"`
+		}{}
+		if message != "" {
+			if err := json.Unmarshal([]byte(message), &pruneResult); err != nil {
+				r.Log.Info("can't parse prune job result", "job", job.Name, "reason", err)
+			}
+		}
+		pruned = pruneResult.Pruned
+	}
+
+	err := patchStatus(ctx, r.Client, backup, func() {
+		status := &backupv1alpha1.PruneStatus{
+			JobName: job.Name,
+			Phase:   phase,
+		}
+		if phase == backupv1alpha1.RunPhaseSucceeded {
+			status.LastPruneTime = job.Status.CompletionTime
+			status.PrunedArtifacts = pruned
+		} else if backup.Status.Prune != nil {
+			status.LastPruneTime = backup.Status.Prune.LastPruneTime
+			status.PrunedArtifacts = backup.Status.Prune.PrunedArtifacts
+		}
+		backup.Status.Prune = status
+	})
+	if err != nil {
+		return err
+	}
+
+	// TTL of finished Jobs may be disabled in the cluster,
+	// so prune Jobs are kept within the Backup history limits too
+	if phase != backupv1alpha1.RunPhaseSucceeded && phase != backupv1alpha1.RunPhaseFailed {
+		return nil
+	}
+	return r.deletePruneJobs(ctx, backup)
+}
 
-	jobOwners := accessor.GetOwnerReferences()
-	for _, jobOwner := range jobOwners {
-		if !*jobOwner.Controller {
+// deletePruneJobs deletes finished prune Jobs of the Backup above its
+// history limits
+func (r *JobReconciler) deletePruneJobs(ctx context.Context, backup *backupv1alpha1.Backup) error {
+	list := &v1.JobList{}
+	err := r.List(ctx, list, client.InNamespace(backup.Namespace),
+		client.MatchingLabels{resources.JobTypeLabel: resources.JobTypePrune})
+	if err != nil {
+		return err
+	}
+	var jobs []v1.Job
+	for _, job := range list.Items {
+		if metav1.IsControlledBy(&job, backup) {
+			jobs = append(jobs, job)
+		}
+	}
+	return deleteFinishedJobs(ctx, r.Client, backup, jobs)
+}
+
+// runResult returns the summary the successful backup Job wrote to its pod
+// termination message, or nil if the Job doesn't report it
+func (r *JobReconciler) runResult(ctx context.Context, job *v1.Job) (*backupv1alpha1.RunResult, error) {
+	message, err := r.terminationMessage(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	runResult, err := parseRunResult(message)
+	if err != nil {
+		r.Log.Info("can't parse backup job result", "job", job.Name, "reason", err)
+		return nil, nil
+	}
+	return runResult, nil
+}
+
+// parseRunResult parses the run summary written as JSON to the termination
+// message. It returns nil if the message is empty.
+func parseRunResult(message string) (*backupv1alpha1.RunResult, error) {
+	if strings.TrimSpace(message) == "" {
+		return nil, nil
+	}
+	runResult := &backupv1alpha1.RunResult{}
+	if err := json.Unmarshal([]byte(message), runResult); err != nil {
+		return nil, err
+	}
+	return runResult, nil
+}
+
+// terminationMessage returns termination message of the copybird
+// container of the successfully finished Job pod
+func (r *JobReconciler) terminationMessage(ctx context.Context, job *v1.Job) (string, error) {
+	pods := &corev1.PodList{}
+	err := r.APIReader.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name})
+	if err != nil {
+		return "", err
+	}
+	return podTerminationMessage(pods.Items), nil
+}
+
+// podTerminationMessage returns termination message of the copybird
+// container of the first succeeded pod, sidecars are ignored
+func podTerminationMessage(pods []corev1.Pod) string {
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodSucceeded {
 			continue
 		}
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			if containerStatus.Name == resources.ContainerName && containerStatus.State.Terminated != nil {
+				return containerStatus.State.Terminated.Message
+			}
+		}
+	}
+	return ""
+}
+
+// getBackup follows Job controller references up to the Backup the Job
+// runs for. Scheduled Jobs are owned by the Backup CronJob, on-demand
+// Jobs by a BackupRun, and auxiliary Jobs like prune and Jobs scheduled in
+// the Controller execution mode by the Backup itself.
+// It returns nil if Job doesn't belong to any Backup.
+func (r *JobReconciler) getBackup(ctx context.Context, job *v1.Job) (*backupv1alpha1.Backup, error) {
+	jobOwner := metav1.GetControllerOf(job)
+	if jobOwner == nil {
+		return nil, nil
+	}
+
+	var backupName string
+	switch jobOwner.Kind {
+	case "CronJob":
 		ownerCronJob := &v1beta1.CronJob{}
 		err := r.Get(ctx, types.NamespacedName{Name: jobOwner.Name, Namespace: job.Namespace}, ownerCronJob)
-		if apierrors.IsNotFound(err) {
-			continue
-		} else if err != nil {
-			log.Info("can't get job owner", "reason", err)
-			continue
+		if err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		cronJobOwner := metav1.GetControllerOf(ownerCronJob)
+		if cronJobOwner == nil {
+			return nil, nil
+		}
+		if cronJobOwner.APIVersion != backupv1alpha1.GroupVersion.String() || cronJobOwner.Kind != "Backup" {
+			return nil, nil
+		}
+		backupName = cronJobOwner.Name
+	case "Backup":
+		if jobOwner.APIVersion != backupv1alpha1.GroupVersion.String() {
+			return nil, nil
+		}
+		backupName = jobOwner.Name
+	case "BackupRun":
+		if jobOwner.APIVersion != backupv1alpha1.GroupVersion.String() {
+			return nil, nil
+		}
+		ownerRun := &backupv1alpha1.BackupRun{}
+		err := r.Get(ctx, types.NamespacedName{Name: jobOwner.Name, Namespace: job.Namespace}, ownerRun)
+		if err != nil {
+			return nil, client.IgnoreNotFound(err)
 		}
+		backupName = ownerRun.Spec.BackupName
+	default:
+		return nil, nil
+	}
 
-		cronJobOwners := ownerCronJob.GetOwnerReferences()
-		for _, cronJobOwner := range cronJobOwners {
-			if !*cronJobOwner.Controller {
-				continue
-			}
-			if cronJobOwner.APIVersion != backupv1alpha1.GroupVersion.String() {
-				continue
-			}
-			if cronJobOwner.Kind != "Backup" {
-				continue
-			}
-			backup := &backupv1alpha1.Backup{}
-			err := r.Get(ctx, types.NamespacedName{Name: cronJobOwner.Name, Namespace: job.Namespace}, backup)
-			if apierrors.IsNotFound(err) {
-				continue
-			} else if err != nil {
-				log.Info("can't get cronjob owner", "reason", err)
-				continue
-			}
-			currentStatus := backupv1alpha1.JobStatus{
-				Name:       job.Name,
-				Success:    false,
-				StartTime:  job.Status.StartTime,
-				FinishTime: job.Status.CompletionTime,
-			}
+	backup := &backupv1alpha1.Backup{}
+	err := r.Get(ctx, types.NamespacedName{Name: backupName, Namespace: job.Namespace}, backup)
+	if err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return backup, nil
+}
 
-			for _, cond := range job.Status.Conditions {
-				if cond.Type == v1.JobComplete {
-					if cond.Status == corev1.ConditionTrue {
-						currentStatus.Success = true
-					}
-				}
-			}
-			update := false
-			for i, statusJob := range backup.Status.Jobs {
-				if statusJob.Name == currentStatus.Name {
-					backup.Status.Jobs[i] = currentStatus
-					update = true
-					break
-				}
-			}
-			if !update {
-				backup.Status.Jobs = append([]backupv1alpha1.JobStatus{currentStatus}, backup.Status.Jobs...)
-			}
-			if len(backup.Status.Jobs) > numberOfJobsToShow {
-				backup.Status.Jobs = backup.Status.Jobs[:numberOfJobsToShow]
-			}
-			err = r.Update(ctx, backup)
-			if err != nil {
-				log.Info("can't update backup status", "reason", err)
-				result.Requeue = true
+// jobPhase maps Job status onto a RunPhase. For failed Jobs it also
+// returns a human readable failure reason.
+func jobPhase(job *v1.Job) (backupv1alpha1.RunPhase, string) {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case v1.JobComplete:
+			return backupv1alpha1.RunPhaseSucceeded, ""
+		case v1.JobFailed:
+			if cond.Message == "" {
+				return backupv1alpha1.RunPhaseFailed, cond.Reason
 			}
+			return backupv1alpha1.RunPhaseFailed, cond.Reason + ": " + cond.Message
 		}
 	}
+	if job.Status.StartTime != nil {
+		return backupv1alpha1.RunPhaseRunning, ""
+	}
+	return backupv1alpha1.RunPhasePending, ""
+}
 
-	return result, nil
+// jobFinishTime returns the time Job has completed or failed at
+func jobFinishTime(job *v1.Job) *metav1.Time {
+	if job.Status.CompletionTime != nil {
+		return job.Status.CompletionTime
+	}
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == v1.JobFailed && cond.Status == corev1.ConditionTrue {
+			return &cond.LastTransitionTime
+		}
+	}
+	return nil
 }
 
 func (r *JobReconciler) SetupWithManager(mgr ctrl.Manager) error {
@@ -0,0 +1,159 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"github.com/copybird/copybird-crd/controllers/resources"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+func terminatedPod(phase corev1.PodPhase, messages map[string]string) corev1.Pod {
+	pod := corev1.Pod{Status: corev1.PodStatus{Phase: phase}}
+	for name, message := range messages {
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name: name,
+			State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{Message: message},
+			},
+		})
+	}
+	return pod
+}
+
+func TestPodTerminationMessage(t *testing.T) {
+	tests := map[string]struct {
+		pods     []corev1.Pod
+		expected string
+	}{
+		"no pods": {},
+		"copybird container": {
+			pods: []corev1.Pod{
+				terminatedPod(corev1.PodSucceeded, map[string]string{resources.ContainerName: "result"}),
+			},
+			expected: "result",
+		},
+		"sidecar": {
+			pods: []corev1.Pod{
+				terminatedPod(corev1.PodSucceeded, map[string]string{
+					"proxy":                 "proxy stopped",
+					resources.ContainerName: "result",
+				}),
+			},
+			expected: "result",
+		},
+		"sidecar only": {
+			pods: []corev1.Pod{
+				terminatedPod(corev1.PodSucceeded, map[string]string{"proxy": "proxy stopped"}),
+			},
+		},
+		"failed pod": {
+			pods: []corev1.Pod{
+				terminatedPod(corev1.PodFailed, map[string]string{resources.ContainerName: "error"}),
+				terminatedPod(corev1.PodSucceeded, map[string]string{resources.ContainerName: "result"}),
+			},
+			expected: "result",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if message := podTerminationMessage(test.pods); message != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, message)
+			}
+		})
+	}
+}
+
+func TestParseRunResult(t *testing.T) {
+	int64Ptr := func(v int64) *int64 { return &v }
+
+	tests := map[string]struct {
+		message  string
+		expected *backupv1alpha1.RunResult
+		err      bool
+	}{
+		"no message": {},
+		"blank message": {
+			message: "\n",
+		},
+		"full result": {
+			message: `{"artifactURI":"s3://backups/mysql.sql.gz","bytesIn":2048,"bytesOut":512,` +
+				`"checksum":"sha256:abc","duration":"1m30s","moduleVersions":{"mysql":"1.2.0"}}`,
+			expected: &backupv1alpha1.RunResult{
+				ArtifactURI:    "s3://backups/mysql.sql.gz",
+				BytesIn:        int64Ptr(2048),
+				BytesOut:       int64Ptr(512),
+				Checksum:       "sha256:abc",
+				Duration:       &metav1.Duration{Duration: 90 * time.Second},
+				ModuleVersions: map[string]string{"mysql": "1.2.0"},
+			},
+		},
+		"empty artifact": {
+			message:  `{"artifactURI":"s3://backups/empty.sql.gz","bytesOut":0}`,
+			expected: &backupv1alpha1.RunResult{ArtifactURI: "s3://backups/empty.sql.gz", BytesOut: int64Ptr(0)},
+		},
+		"bytesIn missing": {
+			message:  `{"artifactURI":"s3://backups/mysql.sql.gz","bytesOut":512}`,
+			expected: &backupv1alpha1.RunResult{ArtifactURI: "s3://backups/mysql.sql.gz", BytesOut: int64Ptr(512)},
+		},
+		"empty input": {
+			message:  `{"artifactURI":"s3://backups/empty.sql.gz","bytesIn":0,"bytesOut":0}`,
+			expected: &backupv1alpha1.RunResult{ArtifactURI: "s3://backups/empty.sql.gz", BytesIn: int64Ptr(0), BytesOut: int64Ptr(0)},
+		},
+		"size missing": {
+			message:  `{"artifactURI":"s3://backups/mysql.sql.gz"}`,
+			expected: &backupv1alpha1.RunResult{ArtifactURI: "s3://backups/mysql.sql.gz"},
+		},
+		"plain text": {
+			message: "backup done",
+			err:     true,
+		},
+		"truncated JSON": {
+			message: `{"artifactURI":"s3://backups/my`,
+			err:     true,
+		},
+		"wrong field type": {
+			message: `{"bytesOut":"512"}`,
+			err:     true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			result, err := parseRunResult(test.message)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !equality.Semantic.DeepEqual(result, test.expected) {
+				t.Errorf("unexpected result: %s", diff.ObjectReflectDiff(test.expected, result))
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "copybird"
+
+// Backup metrics are labeled with the Backup namespace and name
+var backupLabels = []string{"namespace", "name"}
+
+var (
+	lastSuccessTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "backup_last_success_timestamp_seconds",
+		Help:      "Time the latest successful backup run finished at.",
+	}, backupLabels)
+	runDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "backup_run_duration_seconds",
+		Help:      "Duration of finished backup runs.",
+		// from 10 seconds to about 6 hours
+		Buckets: prometheus.ExponentialBuckets(10, 2, 12),
+	}, backupLabels)
+	runsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "backup_runs_total",
+		Help:      "Number of finished backup runs by their result.",
+	}, []string{"namespace", "name", "result"})
+	consecutiveFailures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "backup_consecutive_failures",
+		Help:      "Number of backup runs failed in a row since the latest successful one.",
+	}, backupLabels)
+	stale = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "backup_stale",
+		Help:      "Whether the latest successful backup is older than the Backup maxAge.",
+	}, backupLabels)
+	artifactSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "backup_artifact_size_bytes",
+		Help:      "Size of the artifact written by the latest successful backup run.",
+	}, backupLabels)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		lastSuccessTimestamp,
+		runDuration,
+		runsTotal,
+		consecutiveFailures,
+		stale,
+		artifactSize,
+	)
+}
+
+// observeRunMetrics accounts the finished backup run. The artifact size
+// is reported by successful runs only, negative size means it's unknown.
+func observeRunMetrics(backup *backupv1alpha1.Backup, jobStatus backupv1alpha1.JobStatus, size int64) {
+	result := "failed"
+	if jobStatus.Success {
+		result = "succeeded"
+		if size >= 0 {
+			artifactSize.WithLabelValues(backup.Namespace, backup.Name).Set(float64(size))
+		}
+	}
+	runsTotal.WithLabelValues(backup.Namespace, backup.Name, result).Inc()
+	if jobStatus.StartTime != nil && jobStatus.FinishTime != nil {
+		duration := jobStatus.FinishTime.Sub(jobStatus.StartTime.Time)
+		runDuration.WithLabelValues(backup.Namespace, backup.Name).Observe(duration.Seconds())
+	}
+	updateBackupMetrics(backup)
+}
+
+// updateBackupMetrics sets gauges reflecting the Backup status, so they
+// are restored from it once the controller is restarted
+func updateBackupMetrics(backup *backupv1alpha1.Backup) {
+	if backup.Status.LastSuccessfulTime != nil {
+		lastSuccessTimestamp.WithLabelValues(backup.Namespace, backup.Name).Set(float64(backup.Status.LastSuccessfulTime.Unix()))
+	}
+	consecutiveFailures.WithLabelValues(backup.Namespace, backup.Name).Set(float64(backup.Status.ConsecutiveFailures))
+	// staleness is reported only for Backups with maxAge
+	if backup.Spec.MaxAge == nil {
+		stale.DeleteLabelValues(backup.Namespace, backup.Name)
+	} else if backupv1alpha1.IsConditionTrue(backup.Status.Conditions, backupv1alpha1.ConditionStale) {
+		stale.WithLabelValues(backup.Namespace, backup.Name).Set(1)
+	} else {
+		stale.WithLabelValues(backup.Namespace, backup.Name).Set(0)
+	}
+}
+
+// forgetBackupMetrics removes metrics of the deleted Backup
+func forgetBackupMetrics(backup *backupv1alpha1.Backup) {
+	lastSuccessTimestamp.DeleteLabelValues(backup.Namespace, backup.Name)
+	runDuration.DeleteLabelValues(backup.Namespace, backup.Name)
+	runsTotal.DeleteLabelValues(backup.Namespace, backup.Name, "succeeded")
+	runsTotal.DeleteLabelValues(backup.Namespace, backup.Name, "failed")
+	consecutiveFailures.DeleteLabelValues(backup.Namespace, backup.Name)
+	stale.DeleteLabelValues(backup.Namespace, backup.Name)
+	artifactSize.DeleteLabelValues(backup.Namespace, backup.Name)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// optimisticMergePatch is a merge patch that carries resourceVersion of the
+// patched object, so the API server rejects it with a conflict if the object
+// has been changed since it was read. Plain merge patches replace lists like
+// conditions or finalizers as a whole and would drop concurrent changes.
+type optimisticMergePatch struct {
+	from runtime.Object
+}
+
+// optimisticMergeFrom creates an optimistic merge patch from the original object
+func optimisticMergeFrom(from runtime.Object) client.Patch {
+	return optimisticMergePatch{from: from}
+}
+
+// Type implements client.Patch
+func (p optimisticMergePatch) Type() types.PatchType {
+	return types.MergePatchType
+}
+
+// Data implements client.Patch
+func (p optimisticMergePatch) Data(obj runtime.Object) ([]byte, error) {
+	data, err := client.MergeFrom(p.from).Data(obj)
+	if err != nil {
+		return nil, err
+	}
+	accessor, err := meta.Accessor(p.from)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := map[string]interface{}{}
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, err
+	}
+	metadata, ok := patch["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		patch["metadata"] = metadata
+	}
+	metadata["resourceVersion"] = accessor.GetResourceVersion()
+	return json.Marshal(patch)
+}
+
+// patchMetadata applies mutate to the object metadata and saves it with
+// an optimistic merge patch, retrying on conflicts with concurrent writers
+func patchMetadata(ctx context.Context, c client.Client, obj runtime.Object, mutate func()) error {
+	return retryOnConflict(ctx, c, obj, mutate, func(patch client.Patch) error {
+		return c.Patch(ctx, obj, patch)
+	})
+}
+
+// patchStatus applies mutate to the object status and saves it through the
+// status subresource, retrying on conflicts with concurrent writers. On
+// conflict the object is read again, so mutate must only set the fields
+// owned by the caller.
+func patchStatus(ctx context.Context, c client.Client, obj runtime.Object, mutate func()) error {
+	return retryOnConflict(ctx, c, obj, mutate, func(patch client.Patch) error {
+		return c.Status().Patch(ctx, obj, patch)
+	})
+}
+
+func retryOnConflict(ctx context.Context, c client.Client, obj runtime.Object, mutate func(), apply func(client.Patch) error) error {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return err
+	}
+	orig := obj.DeepCopyObject()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mutate()
+		err := apply(optimisticMergeFrom(orig))
+		if apierrors.IsConflict(err) {
+			if err := c.Get(ctx, key, obj); err != nil {
+				return err
+			}
+			orig = obj.DeepCopyObject()
+		}
+		return err
+	})
+}
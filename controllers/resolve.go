@@ -0,0 +1,108 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// resolveBackup returns a copy of the Backup with its spec turned into the
+// effective configuration copybird Jobs are rendered from: BackupClass
+// modules are merged in, the default compression is set and the storage
+// location output is resolved
+func resolveBackup(ctx context.Context, c client.Reader, backup *backupv1alpha1.Backup) (*backupv1alpha1.Backup, error) {
+	resolved := backup.DeepCopy()
+	class, err := backupv1alpha1.GetBackupClass(ctx, c, backup.Spec.BackupClassName)
+	if err != nil {
+		return nil, err
+	}
+	if class != nil {
+		class.Spec.ApplyTo(&resolved.Spec)
+	}
+	if resolved.Spec.Compress.Type == "" {
+		resolved.Spec.Compress.Type = backupv1alpha1.DefaultCompressType
+	}
+
+	if resolved.Spec.StorageLocation != nil {
+		output, err := getStorageLocationOutput(ctx, c, resolved.Namespace, resolved.Spec.StorageLocation)
+		if err != nil {
+			return nil, err
+		}
+		resolved.Spec.Output = output.Merge(resolved.Spec.Output)
+	}
+	return resolved, nil
+}
+
+// getStorageLocationOutput returns output module of the referenced
+// storage location if the location is available
+func getStorageLocationOutput(ctx context.Context, c client.Reader, namespace string, ref *backupv1alpha1.StorageLocationReference) (backupv1alpha1.Module, error) {
+	var spec backupv1alpha1.BackupStorageLocationSpec
+	var status backupv1alpha1.BackupStorageLocationStatus
+
+	switch ref.Kind {
+	case "", backupv1alpha1.BackupStorageLocationKind:
+		location := &backupv1alpha1.BackupStorageLocation{}
+		if err := c.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, location); err != nil {
+			return backupv1alpha1.Module{}, fmt.Errorf("can't get storage location %q: %v", ref.Name, err)
+		}
+		spec, status = location.Spec, location.Status
+	case backupv1alpha1.ClusterBackupStorageLocationKind:
+		location := &backupv1alpha1.ClusterBackupStorageLocation{}
+		if err := c.Get(ctx, types.NamespacedName{Name: ref.Name}, location); err != nil {
+			return backupv1alpha1.Module{}, fmt.Errorf("can't get cluster storage location %q: %v", ref.Name, err)
+		}
+		spec, status = location.Spec, location.Status
+	default:
+		return backupv1alpha1.Module{}, fmt.Errorf("unknown storage location kind %q", ref.Kind)
+	}
+
+	if !backupv1alpha1.IsConditionTrue(status.Conditions, backupv1alpha1.ConditionAvailable) {
+		return backupv1alpha1.Module{}, fmt.Errorf("storage location %q is not available", ref.Name)
+	}
+	return spec.Output, nil
+}
+
+// scheduleBackup returns the resolved Backup as it is run by the named
+// additional schedule: with the schedule, output and retention of the
+// schedule. A copy of the resolved Backup is returned for the main schedule,
+// so changes made to render one schedule never leak into the others, and
+// nil if there is no such schedule.
+func scheduleBackup(resolved *backupv1alpha1.Backup, name string) *backupv1alpha1.Backup {
+	if name == "" {
+		return resolved.DeepCopy()
+	}
+	for _, schedule := range resolved.Spec.Schedules {
+		if schedule.Name != name {
+			continue
+		}
+		scheduled := resolved.DeepCopy()
+		scheduled.Spec.Schedule = schedule.Schedule
+		if schedule.Output != nil {
+			scheduled.Spec.Output = scheduled.Spec.Output.Merge(*schedule.Output)
+		}
+		if schedule.Retention != nil {
+			scheduled.Spec.Retention = schedule.Retention.DeepCopy()
+		}
+		return scheduled
+	}
+	return nil
+}
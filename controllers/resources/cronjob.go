@@ -2,8 +2,12 @@ package resources
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"strconv"
 	"strings"
+	"time"
 
 	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
 	v1 "k8s.io/api/batch/v1"
@@ -17,11 +21,38 @@ const (
 	outputEnv   = "COPYBIRD_OUTPUT"
 	compressEnv = "COPYBIRD_COMPRESS"
 	encryptEnv  = "COPYBIRD_ENCRYPT"
+	jitterEnv   = "COPYBIRD_JITTER"
+	scopeEnv    = "COPYBIRD_SCOPE"
 )
 
+// ScheduleLabel marks CronJobs of the additional Backup schedules
+// and their Jobs with the schedule name
+const ScheduleLabel = "copybird.org/schedule"
+
+// ScheduledTimeAnnotation is the time the Job started by the controller
+// was scheduled at
+const ScheduledTimeAnnotation = "copybird.org/scheduled-time"
+
+// SpecHashAnnotation is the hash of the CronJob spec rendered by the
+// controller, so changes of the desired spec are told apart from the ones
+// made to the CronJob by the API server defaults or out-of-band edits
+const SpecHashAnnotation = "copybird.org/spec-hash"
+
+// ResumeAtAnnotation is the time the CronJob suspended after a Skip blackout
+// window is resumed at. It is the first run scheduled after the window, so
+// the CronJob starts it rather than a run missed within the window.
+const ResumeAtAnnotation = "copybird.org/resume-at"
+
+// CronJob names are limited, so names of Jobs created
+// by CronJob fit into Job name limit
+const maxCronJobNameLength = 52
+
 type CopyBirdParams struct {
 	Image  string
 	Backup *backupv1alpha1.Backup
+	// Schedule is a name of the additional Backup schedule the CronJob
+	// is rendered for. The main Backup schedule has an empty name.
+	Schedule string
 }
 
 func NewCopyBirdParams(image string, backup *backupv1alpha1.Backup) *CopyBirdParams {
@@ -31,7 +62,165 @@ func NewCopyBirdParams(image string, backup *backupv1alpha1.Backup) *CopyBirdPar
 	}
 }
 
-func (p *CopyBirdParams) MakeCronJob(ctx context.Context) *v1beta1.CronJob {
+// MakeCronJob returns the CronJob scheduling Backup Jobs
+func (p *CopyBirdParams) MakeCronJob(ctx context.Context) (*v1beta1.CronJob, error) {
+	jobSpec, err := p.makeScheduledJobSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	// Backups created with webhooks disabled aren't defaulted. The fields
+	// are set explicitly, since the ones left unset aren't compared to
+	// the live CronJob to detect out-of-band edits.
+	concurrencyPolicy := p.Backup.Spec.ConcurrencyPolicy
+	if concurrencyPolicy == "" {
+		concurrencyPolicy = backupv1alpha1.DefaultConcurrencyPolicy
+	}
+	suspend := p.Backup.Spec.Suspend != nil && *p.Backup.Spec.Suspend
+	successfulJobsHistoryLimit := int32(backupv1alpha1.DefaultSuccessfulJobsHistoryLimit)
+	if p.Backup.Spec.SuccessfulJobsHistoryLimit != nil {
+		successfulJobsHistoryLimit = *p.Backup.Spec.SuccessfulJobsHistoryLimit
+	}
+	failedJobsHistoryLimit := int32(backupv1alpha1.DefaultFailedJobsHistoryLimit)
+	if p.Backup.Spec.FailedJobsHistoryLimit != nil {
+		failedJobsHistoryLimit = *p.Backup.Spec.FailedJobsHistoryLimit
+	}
+
+	var labels map[string]string
+	if p.Schedule != "" {
+		labels = map[string]string{ScheduleLabel: p.Schedule}
+	}
+
+	cronjob := &v1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      MakeCronJobName(p.Backup.Name, p.Schedule),
+			Namespace: p.Backup.Namespace,
+			Labels:    labels,
+		},
+		Spec: v1beta1.CronJobSpec{
+			Schedule:                   p.Backup.Spec.Schedule,
+			Suspend:                    &suspend,
+			ConcurrencyPolicy:          concurrencyPolicy,
+			StartingDeadlineSeconds:    p.Backup.Spec.StartingDeadlineSeconds,
+			SuccessfulJobsHistoryLimit: &successfulJobsHistoryLimit,
+			FailedJobsHistoryLimit:     &failedJobsHistoryLimit,
+			JobTemplate: v1beta1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   p.Backup.Name,
+					Labels: labels,
+				},
+				Spec: jobSpec,
+			},
+		},
+	}
+	hash, err := specHash(cronjob.Spec)
+	if err != nil {
+		return nil, err
+	}
+	cronjob.Annotations = map[string]string{SpecHashAnnotation: hash}
+	return cronjob, nil
+}
+
+// specHash returns the hash of the object spec
+func specHash(spec interface{}) (string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	return strconv.FormatUint(h.Sum64(), 36), nil
+}
+
+// MakeScheduledJob returns a backup Job started on schedule by the controller
+// itself in the Controller execution mode. It is rendered like the Jobs of
+// the Backup CronJob.
+func (p *CopyBirdParams) MakeScheduledJob(ctx context.Context, scheduledTime time.Time) (*v1.Job, error) {
+	jobSpec, err := p.makeScheduledJobSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{
+		JobTypeLabel: JobTypeScheduled,
+	}
+	if p.Schedule != "" {
+		labels[ScheduleLabel] = p.Schedule
+	}
+
+	return &v1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			// Jobs are named after the scheduled time like CronJob ones,
+			// so a run is never started twice
+			Name:      MakeJobName(MakeCronJobName(p.Backup.Name, p.Schedule), strconv.FormatInt(scheduledTime.Unix(), 10)),
+			Namespace: p.Backup.Namespace,
+			Labels:    labels,
+			Annotations: map[string]string{
+				ScheduledTimeAnnotation: scheduledTime.UTC().Format(time.RFC3339),
+			},
+		},
+		Spec: jobSpec,
+	}, nil
+}
+
+// makeScheduledJobSpec returns spec of the Jobs run on schedule
+func (p *CopyBirdParams) makeScheduledJobSpec() (v1.JobSpec, error) {
+	jobSpec := p.makeJobSpec()
+	// only scheduled runs are delayed, on-demand ones start right away
+	if jitter := p.Backup.Spec.Jitter; jitter != nil && jitter.Duration > 0 {
+		container := findContainer(&jobSpec.Template)
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  jitterEnv,
+			Value: jitter.Duration.String(),
+		})
+	}
+	err := applyPodTemplate(&jobSpec.Template, p.Backup.Spec.PodTemplate)
+	return jobSpec, err
+}
+
+// MakeCronJobName returns a name of the CronJob running the named Backup
+// schedule. The main schedule CronJob is named after the Backup.
+func MakeCronJobName(backupName, schedule string) string {
+	if schedule == "" {
+		return backupName
+	}
+	return makeName(backupName, schedule, maxCronJobNameLength)
+}
+
+// MakeJob returns a single backup Job rendered from the same template
+// as the Jobs created by the Backup CronJob
+func (p *CopyBirdParams) MakeJob(ctx context.Context, name string) (*v1.Job, error) {
+	jobSpec := p.makeJobSpec()
+	if err := applyPodTemplate(&jobSpec.Template, p.Backup.Spec.PodTemplate); err != nil {
+		return nil, err
+	}
+
+	return &v1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: p.Backup.Namespace,
+		},
+		Spec: jobSpec,
+	}, nil
+}
+
+// MakeRunNowJob returns a backup Job started with the run-now annotation.
+// The Job is named after the annotation value, so a trigger never starts
+// more than one Job.
+func (p *CopyBirdParams) MakeRunNowJob(ctx context.Context, trigger string) (*v1.Job, error) {
+	hash := fnv.New32a()
+	hash.Write([]byte(trigger))
+	job, err := p.MakeJob(ctx, MakeJobName(p.Backup.Name, fmt.Sprintf("now-%08x", hash.Sum32())))
+	if err != nil {
+		return nil, err
+	}
+	job.Labels = map[string]string{
+		JobTypeLabel: JobTypeRunNow,
+	}
+	return job, nil
+}
+
+func (p *CopyBirdParams) makeJobSpec() v1.JobSpec {
 	env := []corev1.EnvVar{
 		{
 			Name:  inputEnv,
@@ -45,6 +234,9 @@ func (p *CopyBirdParams) MakeCronJob(ctx context.Context) *v1beta1.CronJob {
 		}, {
 			Name:  compressEnv,
 			Value: p.Backup.Spec.Compress.Type,
+		}, {
+			Name:  scopeEnv,
+			Value: p.scope(),
 		},
 	}
 	env = append(env, parseParams(p.Backup.Spec.Input.Params, inputEnv)...)
@@ -55,36 +247,29 @@ func (p *CopyBirdParams) MakeCronJob(ctx context.Context) *v1beta1.CronJob {
 	env = append(env, parseSecrets(p.Backup.Spec.Compress.Secrets, compressEnv)...)
 	env = append(env, parseParams(p.Backup.Spec.Encrypt.Params, encryptEnv)...)
 	env = append(env, parseSecrets(p.Backup.Spec.Encrypt.Secrets, encryptEnv)...)
-	return &v1beta1.CronJob{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      p.Backup.Name,
-			Namespace: p.Backup.Namespace,
-		},
-		Spec: v1beta1.CronJobSpec{
-			Schedule: p.Backup.Spec.Schedule,
-			JobTemplate: v1beta1.JobTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: p.Backup.Name,
-				},
-				Spec: v1.JobSpec{
-					Template: corev1.PodTemplateSpec{
-						ObjectMeta: metav1.ObjectMeta{
-							Name: p.Backup.Name,
-						},
-						Spec: corev1.PodSpec{
-							RestartPolicy: "OnFailure",
-							Containers: []corev1.Container{
-								corev1.Container{
-									Name:  p.Backup.Name,
-									Image: p.Image,
-									// docker entrypoint should work,
-									// but Args being ignored without Command for some reason
-									Command: []string{"/copybird"},
-									Args:    []string{"backup"},
-									Env:     env,
-								},
-							},
-						},
+	restartPolicy := p.Backup.Spec.RestartPolicy
+	if restartPolicy == "" {
+		restartPolicy = backupv1alpha1.DefaultRestartPolicy
+	}
+	return v1.JobSpec{
+		BackoffLimit:            p.Backup.Spec.BackoffLimit,
+		ActiveDeadlineSeconds:   p.Backup.Spec.ActiveDeadlineSeconds,
+		TTLSecondsAfterFinished: p.Backup.Spec.TTLSecondsAfterFinished,
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: p.Backup.Name,
+			},
+			Spec: corev1.PodSpec{
+				RestartPolicy: restartPolicy,
+				Containers: []corev1.Container{
+					corev1.Container{
+						Name:  ContainerName,
+						Image: p.Image,
+						// docker entrypoint should work,
+						// but Args being ignored without Command for some reason
+						Command: []string{"/copybird"},
+						Args:    []string{"backup"},
+						Env:     env,
 					},
 				},
 			},
@@ -92,6 +277,17 @@ func (p *CopyBirdParams) MakeCronJob(ctx context.Context) *v1beta1.CronJob {
 	}
 }
 
+// scope returns the path artifacts of the Backup schedule are kept under in
+// the output, so Backups sharing an output never prune or clean up artifacts
+// of each other. Additional schedules keep artifacts under the Backup scope.
+func (p *CopyBirdParams) scope() string {
+	scope := p.Backup.Namespace + "/" + p.Backup.Name
+	if p.Schedule != "" {
+		scope += "/" + p.Schedule
+	}
+	return scope
+}
+
 func parseParams(params []backupv1alpha1.ModuleParam, prefix string) []corev1.EnvVar {
 	var env []corev1.EnvVar
 	for _, v := range params {
@@ -106,6 +302,9 @@ func parseParams(params []backupv1alpha1.ModuleParam, prefix string) []corev1.En
 func parseSecrets(secrets []backupv1alpha1.ModuleSecret, prefix string) []corev1.EnvVar {
 	var env []corev1.EnvVar
 	for _, v := range secrets {
+		if v.SecretKeyRef == nil {
+			continue
+		}
 		env = append(env, corev1.EnvVar{
 			Name: fmt.Sprintf("%s_%s", prefix, strings.ToUpper(v.SecretKeyRef.Key)),
 			ValueFrom: &corev1.EnvVarSource{
@@ -0,0 +1,80 @@
+package resources
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// ContainerName is the name of the copybird container in Job pods.
+// Pod template overlays customize it with a container of the same name.
+const ContainerName = "copybird"
+
+// applyPodTemplate strategically merges the overlay onto the generated pod
+// template, the same way kubectl merges patches: containers, env variables,
+// volumes, etc. are merged by name, other lists and fields are replaced.
+func applyPodTemplate(template *corev1.PodTemplateSpec, overlay *corev1.PodTemplateSpec) error {
+	if overlay == nil {
+		return nil
+	}
+
+	original, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+
+	// fields unset in the overlay are marshalled as nulls, which would
+	// delete them from the generated template, e.g. containers list
+	data, err := json.Marshal(overlay)
+	if err != nil {
+		return err
+	}
+	patch := map[string]interface{}{}
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return err
+	}
+	pruneNulls(patch)
+	if data, err = json.Marshal(patch); err != nil {
+		return err
+	}
+
+	merged, err := strategicpatch.StrategicMergePatch(original, data, corev1.PodTemplateSpec{})
+	if err != nil {
+		return err
+	}
+	result := corev1.PodTemplateSpec{}
+	if err := json.Unmarshal(merged, &result); err != nil {
+		return err
+	}
+	*template = result
+	return nil
+}
+
+// pruneNulls removes null values from the JSON object recursively
+func pruneNulls(object map[string]interface{}) {
+	for key, value := range object {
+		switch value := value.(type) {
+		case nil:
+			delete(object, key)
+		case map[string]interface{}:
+			pruneNulls(value)
+		case []interface{}:
+			for _, item := range value {
+				if itemObject, ok := item.(map[string]interface{}); ok {
+					pruneNulls(itemObject)
+				}
+			}
+		}
+	}
+}
+
+// findContainer returns the copybird container of the pod template
+func findContainer(template *corev1.PodTemplateSpec) *corev1.Container {
+	for i := range template.Spec.Containers {
+		if template.Spec.Containers[i].Name == ContainerName {
+			return &template.Spec.Containers[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package resources
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+func generatedTemplate() corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "mysql-backup",
+			Labels: map[string]string{"app": "copybird"},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyOnFailure,
+			Containers: []corev1.Container{
+				{
+					Name:    ContainerName,
+					Image:   "copybird/copybird:latest",
+					Command: []string{"/copybird"},
+					Args:    []string{"backup"},
+					Env: []corev1.EnvVar{
+						{Name: "COPYBIRD_INPUT", Value: "mysql"},
+						{Name: "COPYBIRD_OUTPUT", Value: "s3"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestApplyPodTemplate(t *testing.T) {
+	resources := corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+	}
+
+	tests := map[string]struct {
+		overlay *corev1.PodTemplateSpec
+		// expected modifies the generated template into the expected one
+		expected func(template *corev1.PodTemplateSpec)
+	}{
+		"no overlay": {
+			expected: func(template *corev1.PodTemplateSpec) {},
+		},
+		"pod settings": {
+			overlay: &corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"team": "db"},
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: "backup",
+					NodeSelector:       map[string]string{"pool": "batch"},
+				},
+			},
+			expected: func(template *corev1.PodTemplateSpec) {
+				template.Labels["team"] = "db"
+				template.Spec.ServiceAccountName = "backup"
+				template.Spec.NodeSelector = map[string]string{"pool": "batch"}
+			},
+		},
+		"container env": {
+			overlay: &corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name: ContainerName,
+					Env: []corev1.EnvVar{
+						{Name: "COPYBIRD_OUTPUT", Value: "gcs"},
+						{Name: "HTTPS_PROXY", Value: "http://proxy:3128"},
+					},
+				}},
+			}},
+			expected: func(template *corev1.PodTemplateSpec) {
+				template.Spec.Containers[0].Env = []corev1.EnvVar{
+					{Name: "COPYBIRD_INPUT", Value: "mysql"},
+					{Name: "COPYBIRD_OUTPUT", Value: "gcs"},
+					{Name: "HTTPS_PROXY", Value: "http://proxy:3128"},
+				}
+			},
+		},
+		"volumes": {
+			overlay: &corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{{
+					Name:         "scratch",
+					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+				}},
+				Containers: []corev1.Container{{
+					Name:         ContainerName,
+					VolumeMounts: []corev1.VolumeMount{{Name: "scratch", MountPath: "/tmp"}},
+				}},
+			}},
+			expected: func(template *corev1.PodTemplateSpec) {
+				template.Spec.Volumes = []corev1.Volume{{
+					Name:         "scratch",
+					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+				}}
+				template.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: "scratch", MountPath: "/tmp"}}
+			},
+		},
+		"sidecar": {
+			overlay: &corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name:  "proxy",
+					Image: "cloudsql-proxy",
+				}},
+			}},
+			expected: func(template *corev1.PodTemplateSpec) {
+				template.Spec.Containers = append([]corev1.Container{{
+					Name:  "proxy",
+					Image: "cloudsql-proxy",
+				}}, template.Spec.Containers...)
+			},
+		},
+		"copybird command kept": {
+			overlay: &corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name:      ContainerName,
+					Resources: resources,
+				}},
+			}},
+			expected: func(template *corev1.PodTemplateSpec) {
+				template.Spec.Containers[0].Resources = resources
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			template := generatedTemplate()
+			if err := applyPodTemplate(&template, test.overlay); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := generatedTemplate()
+			test.expected(&expected)
+			if !equality.Semantic.DeepEqual(template, expected) {
+				t.Errorf("unexpected template: %s", diff.ObjectReflectDiff(expected, template))
+			}
+			container := findContainer(&template)
+			if container == nil {
+				t.Fatalf("copybird container is missing")
+			}
+			if !equality.Semantic.DeepEqual(container.Command, []string{"/copybird"}) {
+				t.Errorf("copybird command is overridden: %v", container.Command)
+			}
+		})
+	}
+}
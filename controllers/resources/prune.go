@@ -0,0 +1,177 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// JobTypeLabel marks Jobs created by the controller directly
+	JobTypeLabel = "copybird.org/job-type"
+	// JobTypePrune is a JobTypeLabel value of retention enforcement Jobs
+	JobTypePrune = "prune"
+	// JobTypeScheduled is a JobTypeLabel value of backup Jobs started
+	// on schedule in the Controller execution mode
+	JobTypeScheduled = "scheduled"
+	// JobTypeRunNow is a JobTypeLabel value of backup Jobs started
+	// with the run-now annotation
+	JobTypeRunNow = "run-now"
+	// JobTypeCleanup is a JobTypeLabel value of Jobs removing artifacts
+	// of the deleted Backup
+	JobTypeCleanup = "cleanup"
+
+	retentionEnv = "COPYBIRD_RETENTION"
+
+	// outputJobBackoffLimit is a number of prune and cleanup retries.
+	// Artifacts left by a failed prune are removed by the one following
+	// the next backup, failed cleanups are started again by the controller.
+	outputJobBackoffLimit = 2
+
+	// pods created by a Job are labeled with its name,
+	// so Job name must be a valid label value
+	maxJobNameLength = 63
+)
+
+// unscopedOutputTypes are the output modules keeping no artifact paths,
+// so artifacts of a Backup can't be told apart from the ones of the others
+var unscopedOutputTypes = sets.NewString("http")
+
+// OutputScoped reports whether the output keeps Backup artifacts under
+// their scope, so they can be removed without touching other Backups
+func OutputScoped(output backupv1alpha1.Module) bool {
+	return !unscopedOutputTypes.Has(output.Type)
+}
+
+// MakePruneJob returns a Job running "copybird prune" that removes artifacts
+// not kept by the Backup retention policy from the Backup output. Pruned
+// artifacts are reported as JSON in the pod termination message.
+func (p *CopyBirdParams) MakePruneJob(ctx context.Context, name string) (*v1.Job, error) {
+	return p.makeOutputJob(name, "prune", JobTypePrune, parseRetention(p.Backup.Spec.Retention, retentionEnv))
+}
+
+// makeOutputJob returns a Job running the copybird command against the
+// artifacts of the Backup schedule in the Backup output
+func (p *CopyBirdParams) makeOutputJob(name, command, jobType string, extraEnv []corev1.EnvVar) (*v1.Job, error) {
+	output := p.Backup.Spec.Output
+	env := []corev1.EnvVar{
+		{
+			Name:  outputEnv,
+			Value: output.Type,
+		}, {
+			Name:  scopeEnv,
+			Value: p.scope(),
+		},
+	}
+	env = append(env, parseParams(output.Params, outputEnv)...)
+	env = append(env, parseSecrets(output.Secrets, outputEnv)...)
+	env = append(env, extraEnv...)
+
+	// the command runs with the same pod settings as backups,
+	// since it needs the same access to the output
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: "OnFailure",
+			Containers: []corev1.Container{
+				corev1.Container{
+					Name:    ContainerName,
+					Image:   p.Image,
+					Command: []string{"/copybird"},
+					Args:    []string{command},
+					Env:     env,
+				},
+			},
+		},
+	}
+	if err := applyPodTemplate(&template, p.Backup.Spec.PodTemplate); err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{
+		JobTypeLabel: jobType,
+	}
+	if p.Schedule != "" {
+		labels[ScheduleLabel] = p.Schedule
+	}
+
+	// finished Jobs expire like backup Jobs do
+	backoffLimit := int32(outputJobBackoffLimit)
+	return &v1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: p.Backup.Namespace,
+			Labels:    labels,
+		},
+		Spec: v1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: p.Backup.Spec.TTLSecondsAfterFinished,
+			Template:                template,
+		},
+	}, nil
+}
+
+// MakeCleanupJob returns a Job running "copybird cleanup" that removes all
+// artifacts of the Backup from its output. The cleanup is limited to the
+// Backup scope, which includes the scopes of its additional schedules.
+func (p *CopyBirdParams) MakeCleanupJob(ctx context.Context, name string) (*v1.Job, error) {
+	return p.makeOutputJob(name, "cleanup", JobTypeCleanup, nil)
+}
+
+// MakeJobName joins Job name with a suffix, shortening the name if the result
+// doesn't fit into Job name limit. Shortened names end with a hash of the
+// original one to keep them distinct.
+func MakeJobName(name, suffix string) string {
+	return makeName(name, suffix, maxJobNameLength)
+}
+
+// makeName joins name with a suffix shortening the result to the limit
+func makeName(name, suffix string, limit int) string {
+	if len(name)+len(suffix)+1 <= limit {
+		return name + "-" + suffix
+	}
+	// leave room for the hash and at least a part of the name
+	if len(suffix) > limit/2 {
+		suffix = suffix[:limit/2]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	hash := strconv.FormatUint(uint64(h.Sum32()), 36)
+	name = name[:limit-len(suffix)-len(hash)-2]
+	return name + "-" + hash + "-" + suffix
+}
+
+func parseRetention(retention *backupv1alpha1.RetentionPolicy, prefix string) []corev1.EnvVar {
+	var env []corev1.EnvVar
+	if retention == nil {
+		return env
+	}
+	addInt := func(key string, value *int32) {
+		if value != nil {
+			env = append(env, corev1.EnvVar{
+				Name:  fmt.Sprintf("%s_%s", prefix, key),
+				Value: strconv.Itoa(int(*value)),
+			})
+		}
+	}
+	addInt("KEEP_LAST", retention.KeepLast)
+	if retention.KeepFor != nil {
+		env = append(env, corev1.EnvVar{
+			Name:  fmt.Sprintf("%s_%s", prefix, "KEEP_FOR"),
+			Value: retention.KeepFor.Duration.String(),
+		})
+	}
+	addInt("KEEP_DAILY", retention.KeepDaily)
+	addInt("KEEP_WEEKLY", retention.KeepWeekly)
+	addInt("KEEP_MONTHLY", retention.KeepMonthly)
+	return env
+}
@@ -0,0 +1,204 @@
+package resources
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestParseRetention(t *testing.T) {
+	int32Ptr := func(v int32) *int32 { return &v }
+
+	tests := map[string]struct {
+		retention *backupv1alpha1.RetentionPolicy
+		env       []corev1.EnvVar
+	}{
+		"no retention": {},
+		"keep last": {
+			retention: &backupv1alpha1.RetentionPolicy{KeepLast: int32Ptr(7)},
+			env: []corev1.EnvVar{
+				{Name: "COPYBIRD_RETENTION_KEEP_LAST", Value: "7"},
+			},
+		},
+		"all rules": {
+			retention: &backupv1alpha1.RetentionPolicy{
+				KeepLast:    int32Ptr(3),
+				KeepFor:     &metav1.Duration{Duration: 36 * time.Hour},
+				KeepDaily:   int32Ptr(7),
+				KeepWeekly:  int32Ptr(4),
+				KeepMonthly: int32Ptr(0),
+			},
+			env: []corev1.EnvVar{
+				{Name: "COPYBIRD_RETENTION_KEEP_LAST", Value: "3"},
+				{Name: "COPYBIRD_RETENTION_KEEP_FOR", Value: "36h0m0s"},
+				{Name: "COPYBIRD_RETENTION_KEEP_DAILY", Value: "7"},
+				{Name: "COPYBIRD_RETENTION_KEEP_WEEKLY", Value: "4"},
+				{Name: "COPYBIRD_RETENTION_KEEP_MONTHLY", Value: "0"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			env := parseRetention(test.retention, retentionEnv)
+			if !equality.Semantic.DeepEqual(env, test.env) {
+				t.Errorf("unexpected env: %s", diff.ObjectReflectDiff(test.env, env))
+			}
+		})
+	}
+}
+
+func TestMakeJobName(t *testing.T) {
+	long := strings.Repeat("a", 70)
+
+	tests := map[string]struct {
+		name, suffix string
+		expected     string
+	}{
+		"short name": {
+			name:     "mysql-backup",
+			suffix:   "prune",
+			expected: "mysql-backup-prune",
+		},
+		"name at the limit": {
+			name:     strings.Repeat("a", 57),
+			suffix:   "prune",
+			expected: strings.Repeat("a", 57) + "-prune",
+		},
+		"long name": {
+			name:   long,
+			suffix: "prune",
+		},
+		"long name and suffix": {
+			name:   long,
+			suffix: long,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			jobName := MakeJobName(test.name, test.suffix)
+			if test.expected != "" && jobName != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, jobName)
+			}
+			if len(jobName) > maxJobNameLength {
+				t.Errorf("name %s is longer than %d", jobName, maxJobNameLength)
+			}
+			for _, msg := range validation.IsValidLabelValue(jobName) {
+				t.Errorf("name %s isn't a valid label value: %s", jobName, msg)
+			}
+		})
+	}
+
+	// shortened names stay distinct
+	if MakeJobName(long, "prune") == MakeJobName(long+"b", "prune") {
+		t.Errorf("shortened names of different Jobs are equal")
+	}
+	if !strings.HasSuffix(MakeJobName(long, "prune"), "-prune") {
+		t.Errorf("shortened name lost the suffix: %s", MakeJobName(long, "prune"))
+	}
+}
+
+func TestMakeCleanupJob(t *testing.T) {
+	backup := &backupv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysql-backup", Namespace: "db"},
+		Spec: backupv1alpha1.BackupSpec{
+			Output: backupv1alpha1.Module{
+				Type:   "s3",
+				Params: []backupv1alpha1.ModuleParam{{Key: "bucket", Value: "backups"}},
+			},
+		},
+	}
+
+	tests := map[string]struct {
+		schedule string
+		env      []corev1.EnvVar
+	}{
+		"main output": {
+			env: []corev1.EnvVar{
+				{Name: "COPYBIRD_OUTPUT", Value: "s3"},
+				{Name: "COPYBIRD_SCOPE", Value: "db/mysql-backup"},
+				{Name: "COPYBIRD_OUTPUT_BUCKET", Value: "backups"},
+			},
+		},
+		"schedule output": {
+			schedule: "hourly",
+			env: []corev1.EnvVar{
+				{Name: "COPYBIRD_OUTPUT", Value: "s3"},
+				{Name: "COPYBIRD_SCOPE", Value: "db/mysql-backup/hourly"},
+				{Name: "COPYBIRD_OUTPUT_BUCKET", Value: "backups"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			copybird := NewCopyBirdParams("copybird/copybird:latest", backup)
+			copybird.Schedule = test.schedule
+			job, err := copybird.MakeCleanupJob(context.Background(), "mysql-backup-cleanup")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			container := findContainer(&job.Spec.Template)
+			if container == nil {
+				t.Fatalf("copybird container is missing")
+			}
+			if !equality.Semantic.DeepEqual(container.Args, []string{"cleanup"}) {
+				t.Errorf("unexpected args: %v", container.Args)
+			}
+			if !equality.Semantic.DeepEqual(container.Env, test.env) {
+				t.Errorf("unexpected env: %s", diff.ObjectReflectDiff(test.env, container.Env))
+			}
+		})
+	}
+}
+
+func TestOutputScoped(t *testing.T) {
+	if !OutputScoped(backupv1alpha1.Module{Type: "s3"}) {
+		t.Errorf("s3 output isn't scoped")
+	}
+	if OutputScoped(backupv1alpha1.Module{Type: "http"}) {
+		t.Errorf("http output is scoped")
+	}
+}
+
+func TestMakePruneJob(t *testing.T) {
+	int32Ptr := func(v int32) *int32 { return &v }
+	backup := &backupv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysql-backup", Namespace: "db"},
+		Spec: backupv1alpha1.BackupSpec{
+			Output:    backupv1alpha1.Module{Type: "s3"},
+			Retention: &backupv1alpha1.RetentionPolicy{KeepLast: int32Ptr(7)},
+		},
+	}
+
+	copybird := NewCopyBirdParams("copybird/copybird:latest", backup)
+	copybird.Schedule = "hourly"
+	job, err := copybird.MakePruneJob(context.Background(), "mysql-backup-hourly-prune")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	env := []corev1.EnvVar{
+		{Name: "COPYBIRD_OUTPUT", Value: "s3"},
+		{Name: "COPYBIRD_SCOPE", Value: "db/mysql-backup/hourly"},
+		{Name: "COPYBIRD_RETENTION_KEEP_LAST", Value: "7"},
+	}
+	container := findContainer(&job.Spec.Template)
+	if container == nil {
+		t.Fatalf("copybird container is missing")
+	}
+	if !equality.Semantic.DeepEqual(container.Env, env) {
+		t.Errorf("unexpected env: %s", diff.ObjectReflectDiff(env, container.Env))
+	}
+	if job.Labels[ScheduleLabel] != "hourly" {
+		t.Errorf("prune job isn't labeled with the schedule: %v", job.Labels)
+	}
+}
@@ -0,0 +1,94 @@
+package resources
+
+import (
+	"context"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	decryptEnv    = "COPYBIRD_DECRYPT"
+	decompressEnv = "COPYBIRD_DECOMPRESS"
+	artifactEnv   = "COPYBIRD_ARTIFACT"
+)
+
+type RestoreParams struct {
+	Image   string
+	Restore *backupv1alpha1.Restore
+}
+
+func NewRestoreParams(image string, restore *backupv1alpha1.Restore) *RestoreParams {
+	return &RestoreParams{
+		Image:   image,
+		Restore: restore,
+	}
+}
+
+// MakeJob returns a one-shot Job running "copybird restore". Restore
+// reverses the backup wiring: the source (storage) module becomes copybird
+// input and the target (database) module becomes copybird output.
+func (p *RestoreParams) MakeJob(ctx context.Context) *v1.Job {
+	spec := p.Restore.Spec
+	env := []corev1.EnvVar{
+		{
+			Name:  inputEnv,
+			Value: spec.Source.Type,
+		}, {
+			Name:  outputEnv,
+			Value: spec.Target.Type,
+		}, {
+			Name:  decryptEnv,
+			Value: spec.Decrypt.Type,
+		}, {
+			Name:  decompressEnv,
+			Value: spec.Decompress.Type,
+		},
+	}
+	if spec.Artifact != "" {
+		env = append(env, corev1.EnvVar{
+			Name:  artifactEnv,
+			Value: spec.Artifact,
+		})
+	}
+	env = append(env, parseParams(spec.Source.Params, inputEnv)...)
+	env = append(env, parseSecrets(spec.Source.Secrets, inputEnv)...)
+	env = append(env, parseParams(spec.Target.Params, outputEnv)...)
+	env = append(env, parseSecrets(spec.Target.Secrets, outputEnv)...)
+	env = append(env, parseParams(spec.Decrypt.Params, decryptEnv)...)
+	env = append(env, parseSecrets(spec.Decrypt.Secrets, decryptEnv)...)
+	env = append(env, parseParams(spec.Decompress.Params, decompressEnv)...)
+	env = append(env, parseSecrets(spec.Decompress.Secrets, decompressEnv)...)
+
+	// restoring twice on top of a partially restored database is rarely
+	// what user wants, so failed restore is never retried automatically
+	backoffLimit := int32(0)
+	return &v1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.Restore.Name,
+			Namespace: p.Restore.Namespace,
+		},
+		Spec: v1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: p.Restore.Name,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						corev1.Container{
+							Name:    ContainerName,
+							Image:   p.Image,
+							Command: []string{"/copybird"},
+							Args:    []string{"restore"},
+							Env:     env,
+						},
+					},
+				},
+			},
+		},
+	}
+}
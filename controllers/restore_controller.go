@@ -0,0 +1,189 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"github.com/copybird/copybird-crd/controllers/resources"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// RestoreReconciler reconciles a Restore object
+type RestoreReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=copybird.org,resources=restores,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=copybird.org,resources=restores/status,verbs=get;update;patch
+
+// Reconcile implements controller reconcilation logic
+func (r *RestoreReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("restore", req.NamespacedName)
+
+	restore := &backupv1alpha1.Restore{}
+	result := ctrl.Result{
+		Requeue: false,
+	}
+
+	if err := r.Get(ctx, req.NamespacedName, restore); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("Object not in the queue", "object", req.NamespacedName)
+		} else {
+			log.Error(err, "Failed to get runtime object from request")
+		}
+		return result, nil
+	}
+
+	switch restore.Status.Phase {
+	case backupv1alpha1.RunPhaseSucceeded, backupv1alpha1.RunPhaseFailed:
+		// restore is a one-shot operation, nothing to do once it is finished
+		return result, nil
+	}
+
+	desired := restore.DeepCopy()
+	if err := r.reconcile(ctx, desired); err != nil {
+		result.Requeue = true
+		log.Info("reconcilation error", "reason", err)
+		return result, err
+	}
+
+	err := patchStatus(ctx, r.Client, restore, func() {
+		restore.Status = desired.Status
+	})
+	if err != nil {
+		result.Requeue = true
+		log.Info("failed to update object status", "reason", err)
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (r *RestoreReconciler) reconcile(ctx context.Context, restore *backupv1alpha1.Restore) error {
+	log := r.Log.WithName("reconciler")
+
+	job := &v1.Job{}
+	if restore.Status.JobName == "" {
+		backup := &backupv1alpha1.Backup{}
+		if restore.Spec.BackupName != "" {
+			err := r.Get(ctx, types.NamespacedName{Name: restore.Spec.BackupName, Namespace: restore.Namespace}, backup)
+			if apierrors.IsNotFound(err) {
+				restore.Status.Phase = backupv1alpha1.RunPhaseFailed
+				restore.Status.Reason = fmt.Sprintf("backup %q not found", restore.Spec.BackupName)
+				return nil
+			} else if err != nil {
+				return err
+			}
+			// the Backup is restored with modules of its class
+			backup, err = resolveBackup(ctx, r.Client, backup)
+			if err != nil {
+				restore.Status.Phase = backupv1alpha1.RunPhaseFailed
+				restore.Status.Reason = err.Error()
+				return nil
+			}
+		}
+
+		resolved, err := resolveRestore(restore, backup)
+		if err != nil {
+			restore.Status.Phase = backupv1alpha1.RunPhaseFailed
+			restore.Status.Reason = err.Error()
+			return nil
+		}
+
+		job = resources.NewRestoreParams(backupImage(log, backup), resolved).MakeJob(ctx)
+		if err := controllerutil.SetControllerReference(restore, job, r.Scheme); err != nil {
+			return err
+		}
+		err = r.Create(ctx, job)
+		if apierrors.IsAlreadyExists(err) {
+			if err := r.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, job); err != nil {
+				return err
+			}
+			// restoring with a Job of someone else would report its outcome
+			if !metav1.IsControlledBy(job, restore) {
+				restore.Status.Phase = backupv1alpha1.RunPhaseFailed
+				restore.Status.Reason = fmt.Sprintf("job %q already exists", job.Name)
+				return nil
+			}
+		} else if err != nil {
+			return err
+		}
+		log.Info("Restore job created", "job", job.Name)
+		restore.Status.JobName = job.Name
+	} else {
+		err := r.Get(ctx, types.NamespacedName{Name: restore.Status.JobName, Namespace: restore.Namespace}, job)
+		if apierrors.IsNotFound(err) {
+			restore.Status.Phase = backupv1alpha1.RunPhaseFailed
+			restore.Status.Reason = fmt.Sprintf("restore job %q not found", restore.Status.JobName)
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	restore.Status.Phase, restore.Status.Reason = jobPhase(job)
+	restore.Status.StartTime = job.Status.StartTime
+	restore.Status.FinishTime = jobFinishTime(job)
+	return nil
+}
+
+// resolveRestore returns a copy of the Restore with the modules that are
+// not set explicitly taken from the Backup it refers to
+func resolveRestore(restore *backupv1alpha1.Restore, backup *backupv1alpha1.Backup) (*backupv1alpha1.Restore, error) {
+	resolved := restore.DeepCopy()
+	if resolved.Spec.Source.Type == "" {
+		resolved.Spec.Source = backup.Spec.Output
+	}
+	if resolved.Spec.Decrypt.Type == "" {
+		resolved.Spec.Decrypt = backup.Spec.Encrypt
+	}
+	if resolved.Spec.Decompress.Type == "" {
+		resolved.Spec.Decompress = backup.Spec.Compress
+	}
+	if resolved.Spec.Target.Type == "" {
+		resolved.Spec.Target = backup.Spec.Input
+	}
+
+	if resolved.Spec.Source.Type == "" {
+		return nil, fmt.Errorf("source module is not defined")
+	}
+	if resolved.Spec.Target.Type == "" {
+		return nil, fmt.Errorf("target module is not defined")
+	}
+	return resolved, nil
+}
+
+func (r *RestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&backupv1alpha1.Restore{}).
+		Owns(&v1.Job{}).
+		Complete(r)
+}
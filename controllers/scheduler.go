@@ -0,0 +1,235 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"github.com/copybird/copybird-crd/controllers/resources"
+	cronschedule "github.com/copybird/copybird-crd/pkg/schedule"
+	"github.com/robfig/cron/v3"
+	v1 "k8s.io/api/batch/v1"
+	"k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// missedRunGracePeriod is the starting deadline of the latest run with the
+// Skip catch-up policy if the Backup doesn't define one
+const missedRunGracePeriod = time.Minute
+
+// runSchedule starts backup Jobs of the named schedule in the Controller
+// execution mode and returns the new last schedule time. Runs scheduled
+// while the Backup is suspended are handled by the catch-up policy once it
+// is resumed, except the ones scheduled before skipUntil, which are dropped.
+func (r *BackupReconciler) runSchedule(ctx context.Context, backup *backupv1alpha1.Backup, copybird *resources.CopyBirdParams,
+	schedule cron.Schedule, suspended bool, skipUntil *metav1.Time) (*metav1.Time, error) {
+	now := time.Now()
+	last := lastScheduleTime(backup, copybird.Schedule)
+	if last == nil {
+		// runs are scheduled from the time the mode is enabled
+		// rather than caught up since the Backup creation
+		started := metav1.NewTime(now)
+		return &started, nil
+	}
+	if skipUntil != nil && last.Before(skipUntil) {
+		last = skipUntil.DeepCopy()
+	}
+	if suspended {
+		return last, nil
+	}
+
+	// schedules have a second precision, so runs due are the ones in (last, now]
+	due := cronschedule.Runs(schedule, last.Add(time.Second), now.Add(time.Second))
+	if len(due) == 0 {
+		return last, nil
+	}
+	latest := metav1.NewTime(due[len(due)-1])
+
+	spec := copybird.Backup.Spec
+	var deadline *time.Duration
+	if spec.StartingDeadlineSeconds != nil {
+		d := time.Duration(*spec.StartingDeadlineSeconds) * time.Second
+		deadline = &d
+	}
+	switch spec.CatchUpPolicy {
+	case backupv1alpha1.CatchUpPolicyRunAll:
+	case backupv1alpha1.CatchUpPolicySkip:
+		if deadline == nil {
+			d := missedRunGracePeriod
+			deadline = &d
+		}
+		due = due[len(due)-1:]
+	default:
+		due = due[len(due)-1:]
+	}
+
+	jobs, err := r.scheduledJobs(ctx, backup, copybird.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	for _, scheduledTime := range due {
+		if deadline != nil && now.Sub(scheduledTime) > *deadline {
+			recordSkippedRun(&backup.Status, backupv1alpha1.SkippedRun{
+				Schedule:      copybird.Schedule,
+				ScheduledTime: metav1.NewTime(scheduledTime),
+			})
+			continue
+		}
+		job, err := r.startScheduledJob(ctx, backup, copybird, scheduledTime, jobs)
+		if err != nil {
+			return nil, err
+		}
+		if job != nil {
+			jobs = append(jobs, *job)
+		}
+	}
+
+	if err := deleteFinishedJobs(ctx, r.Client, copybird.Backup, jobs); err != nil {
+		return nil, err
+	}
+	return &latest, nil
+}
+
+// startScheduledJob starts the Job of the run scheduled at the time honoring
+// the Backup concurrency policy. The Job is not started if the policy forbids
+// running it alongside active Jobs of the schedule.
+func (r *BackupReconciler) startScheduledJob(ctx context.Context, backup *backupv1alpha1.Backup, copybird *resources.CopyBirdParams,
+	scheduledTime time.Time, jobs []v1.Job) (*v1.Job, error) {
+	var active []v1.Job
+	for _, job := range jobs {
+		if jobFinishTime(&job) == nil {
+			active = append(active, job)
+		}
+	}
+
+	switch copybird.Backup.Spec.ConcurrencyPolicy {
+	case v1beta1.AllowConcurrent:
+	case v1beta1.ReplaceConcurrent:
+		for i := range active {
+			err := r.Delete(ctx, &active[i], client.PropagationPolicy(metav1.DeletePropagationBackground))
+			if err != nil && !apierrors.IsNotFound(err) {
+				return nil, err
+			}
+			r.Log.Info("Active job replaced", "job", active[i].Name)
+		}
+	default:
+		if len(active) != 0 {
+			r.Log.Info("Scheduled run skipped, previous one is still running",
+				"backup", backup.Name, "schedule", copybird.Schedule, "job", active[0].Name)
+			recordSkippedRun(&backup.Status, backupv1alpha1.SkippedRun{
+				Schedule:      copybird.Schedule,
+				ScheduledTime: metav1.NewTime(scheduledTime),
+			})
+			r.Recorder.Eventf(backup, corev1.EventTypeWarning, "RunSkipped",
+				"Scheduled run skipped, job %s is still running", active[0].Name)
+			return nil, nil
+		}
+	}
+
+	job, err := copybird.MakeScheduledJob(ctx, scheduledTime)
+	if err != nil {
+		return nil, err
+	}
+	if err := controllerutil.SetControllerReference(backup, job, r.Scheme); err != nil {
+		return nil, err
+	}
+	if err := r.Create(ctx, job); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	r.Log.Info("Scheduled job created", "job", job.Name)
+	return job, nil
+}
+
+// scheduledJobs returns Jobs started by the controller for the named Backup schedule
+func (r *BackupReconciler) scheduledJobs(ctx context.Context, backup *backupv1alpha1.Backup, schedule string) ([]v1.Job, error) {
+	list := &v1.JobList{}
+	err := r.List(ctx, list, client.InNamespace(backup.Namespace),
+		client.MatchingLabels{resources.JobTypeLabel: resources.JobTypeScheduled})
+	if err != nil {
+		return nil, err
+	}
+	var jobs []v1.Job
+	for _, job := range list.Items {
+		if metav1.IsControlledBy(&job, backup) && job.Labels[resources.ScheduleLabel] == schedule {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// deleteFinishedJobs deletes finished Jobs above the Backup history limits
+func deleteFinishedJobs(ctx context.Context, c client.Client, backup *backupv1alpha1.Backup, jobs []v1.Job) error {
+	var successful, failed []v1.Job
+	for _, job := range jobs {
+		switch phase, _ := jobPhase(&job); phase {
+		case backupv1alpha1.RunPhaseSucceeded:
+			successful = append(successful, job)
+		case backupv1alpha1.RunPhaseFailed:
+			failed = append(failed, job)
+		}
+	}
+
+	successfulLimit, failedLimit := int32(backupv1alpha1.DefaultSuccessfulJobsHistoryLimit), int32(backupv1alpha1.DefaultFailedJobsHistoryLimit)
+	if backup.Spec.SuccessfulJobsHistoryLimit != nil {
+		successfulLimit = *backup.Spec.SuccessfulJobsHistoryLimit
+	}
+	if backup.Spec.FailedJobsHistoryLimit != nil {
+		failedLimit = *backup.Spec.FailedJobsHistoryLimit
+	}
+
+	for _, history := range []struct {
+		jobs  []v1.Job
+		limit int32
+	}{{successful, successfulLimit}, {failed, failedLimit}} {
+		if int32(len(history.jobs)) <= history.limit {
+			continue
+		}
+		// the latest Jobs are kept
+		sort.Slice(history.jobs, func(i, j int) bool {
+			return history.jobs[j].CreationTimestamp.Before(&history.jobs[i].CreationTimestamp)
+		})
+		for i := range history.jobs[history.limit:] {
+			job := &history.jobs[int(history.limit)+i]
+			err := c.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+			if err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lastScheduleTime returns the last schedule time of the named Backup schedule
+func lastScheduleTime(backup *backupv1alpha1.Backup, schedule string) *metav1.Time {
+	if schedule == "" {
+		return backup.Status.LastScheduleTime.DeepCopy()
+	}
+	if status := findScheduleStatus(backup.Status.Schedules, schedule); status != nil {
+		return status.LastScheduleTime.DeepCopy()
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	"github.com/copybird/copybird-crd/controllers/resources"
+	"github.com/robfig/cron/v3"
+	v1 "k8s.io/api/batch/v1"
+	"k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// scheduledBackup returns a Backup run by the controller
+func scheduledBackup() *backupv1alpha1.Backup {
+	return &backupv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysql-backup", Namespace: "db", UID: "backup-uid"},
+		Spec: backupv1alpha1.BackupSpec{
+			Schedule:      "@every 1h",
+			Input:         backupv1alpha1.Module{Type: "mysql"},
+			Output:        backupv1alpha1.Module{Type: "s3"},
+			ExecutionMode: backupv1alpha1.ExecutionModeController,
+		},
+	}
+}
+
+// jobNames returns names of the Jobs in the Backup namespace
+func jobNames(t *testing.T, r *BackupReconciler) sets.String {
+	t.Helper()
+	list := &v1.JobList{}
+	if err := r.List(context.Background(), list, client.InNamespace("db")); err != nil {
+		t.Fatalf("can't list jobs: %v", err)
+	}
+	names := sets.NewString()
+	for _, job := range list.Items {
+		names.Insert(job.Name)
+	}
+	return names
+}
+
+func TestRunSchedule(t *testing.T) {
+	int64Ptr := func(v int64) *int64 { return &v }
+	now := time.Now()
+	// an hourly schedule firing relative to the last schedule time,
+	// so runs are due 4h30m, 3h30m, 2h30m, 1h30m and 30m ago
+	schedule := cron.ConstantDelaySchedule{Delay: time.Hour}
+	last := now.Add(-5*time.Hour - 30*time.Minute).Truncate(time.Second)
+	latest := last.Add(5 * time.Hour)
+
+	tests := map[string]struct {
+		mutate    func(backup *backupv1alpha1.Backup)
+		suspended bool
+		skipUntil *metav1.Time
+		// jobs is the number of started Jobs, skipped is the number of
+		// runs recorded as skipped
+		jobs, skipped int
+		expected      time.Time
+	}{
+		"first run": {
+			mutate: func(backup *backupv1alpha1.Backup) { backup.Status.LastScheduleTime = nil },
+		},
+		"run once": {
+			jobs:     1,
+			expected: latest,
+		},
+		"run all": {
+			mutate: func(backup *backupv1alpha1.Backup) {
+				backup.Spec.CatchUpPolicy = backupv1alpha1.CatchUpPolicyRunAll
+				backup.Spec.ConcurrencyPolicy = v1beta1.AllowConcurrent
+			},
+			jobs:     5,
+			expected: latest,
+		},
+		"run all within the starting deadline": {
+			mutate: func(backup *backupv1alpha1.Backup) {
+				backup.Spec.CatchUpPolicy = backupv1alpha1.CatchUpPolicyRunAll
+				backup.Spec.StartingDeadlineSeconds = int64Ptr(2 * 60 * 60)
+				backup.Spec.ConcurrencyPolicy = v1beta1.AllowConcurrent
+			},
+			jobs:     2,
+			skipped:  3,
+			expected: latest,
+		},
+		"run all forbidding concurrent runs": {
+			mutate: func(backup *backupv1alpha1.Backup) {
+				backup.Spec.CatchUpPolicy = backupv1alpha1.CatchUpPolicyRunAll
+			},
+			jobs:     1,
+			skipped:  4,
+			expected: latest,
+		},
+		"skip after the grace period": {
+			mutate: func(backup *backupv1alpha1.Backup) {
+				backup.Spec.CatchUpPolicy = backupv1alpha1.CatchUpPolicySkip
+			},
+			skipped:  1,
+			expected: latest,
+		},
+		"skip within the starting deadline": {
+			mutate: func(backup *backupv1alpha1.Backup) {
+				backup.Spec.CatchUpPolicy = backupv1alpha1.CatchUpPolicySkip
+				backup.Spec.StartingDeadlineSeconds = int64Ptr(60 * 60)
+			},
+			jobs:     1,
+			expected: latest,
+		},
+		"suspended": {
+			suspended: true,
+			expected:  last,
+		},
+		"runs before skip until dropped": {
+			mutate: func(backup *backupv1alpha1.Backup) {
+				backup.Spec.CatchUpPolicy = backupv1alpha1.CatchUpPolicyRunAll
+			},
+			skipUntil: &metav1.Time{Time: now.Add(-time.Hour - 45*time.Minute).Truncate(time.Second)},
+			jobs:      1,
+			expected:  now.Add(-45 * time.Minute).Truncate(time.Second),
+		},
+		"suspended until skip until": {
+			suspended: true,
+			skipUntil: &metav1.Time{Time: now.Add(-time.Hour).Truncate(time.Second)},
+			expected:  now.Add(-time.Hour).Truncate(time.Second),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			backup := scheduledBackup()
+			backup.Status.LastScheduleTime = &metav1.Time{Time: last}
+			if test.mutate != nil {
+				test.mutate(backup)
+			}
+			r := newTestBackupReconciler(t, backup)
+			copybird := resources.NewCopyBirdParams("copybird/copybird:latest", backup)
+
+			started := time.Now()
+			scheduled, err := r.runSchedule(context.Background(), backup, copybird, schedule, test.suspended, test.skipUntil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if scheduled == nil {
+				t.Fatalf("no last schedule time")
+			}
+			if test.expected.IsZero() {
+				// the first run is scheduled from now
+				if scheduled.Time.Before(started.Truncate(time.Second)) || scheduled.Time.After(time.Now()) {
+					t.Errorf("expected last schedule time now, got %v", scheduled.Time)
+				}
+			} else if !scheduled.Time.Equal(test.expected) {
+				t.Errorf("expected last schedule time %v, got %v", test.expected, scheduled.Time)
+			}
+			if jobs := jobNames(t, r); jobs.Len() != test.jobs {
+				t.Errorf("expected %d jobs, got %v", test.jobs, jobs.List())
+			}
+			if len(backup.Status.SkippedRuns) != test.skipped {
+				t.Errorf("expected %d skipped runs, got %v", test.skipped, backup.Status.SkippedRuns)
+			}
+		})
+	}
+}
+
+func TestStartScheduledJobConcurrencyPolicies(t *testing.T) {
+	previous := time.Now().Add(-time.Hour).Truncate(time.Second)
+	scheduled := time.Now().Truncate(time.Second)
+
+	tests := map[string]struct {
+		policy   v1beta1.ConcurrencyPolicy
+		finished bool
+		// kept is whether the previous Job is kept, started is whether
+		// the scheduled one is started
+		kept, started bool
+	}{
+		"allow": {
+			policy:  v1beta1.AllowConcurrent,
+			kept:    true,
+			started: true,
+		},
+		"replace": {
+			policy:  v1beta1.ReplaceConcurrent,
+			started: true,
+		},
+		"forbid": {
+			policy: v1beta1.ForbidConcurrent,
+			kept:   true,
+		},
+		"forbid with the previous run finished": {
+			policy:   v1beta1.ForbidConcurrent,
+			finished: true,
+			kept:     true,
+			started:  true,
+		},
+		"forbid by default": {
+			kept: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			backup := scheduledBackup()
+			backup.Spec.ConcurrencyPolicy = test.policy
+			r := newTestBackupReconciler(t, backup)
+			copybird := resources.NewCopyBirdParams("copybird/copybird:latest", backup)
+
+			job, err := r.startScheduledJob(ctx, backup, copybird, previous, nil)
+			if err != nil || job == nil {
+				t.Fatalf("can't start the previous job: %v", err)
+			}
+			if test.finished {
+				job.Status.CompletionTime = &metav1.Time{Time: previous.Add(time.Minute)}
+				job.Status.Conditions = []v1.JobCondition{{Type: v1.JobComplete, Status: corev1.ConditionTrue}}
+				if err := r.Update(ctx, job); err != nil {
+					t.Fatalf("can't update job: %v", err)
+				}
+			}
+			jobs, err := r.scheduledJobs(ctx, backup, "")
+			if err != nil {
+				t.Fatalf("can't list jobs: %v", err)
+			}
+
+			if _, err := r.startScheduledJob(ctx, backup, copybird, scheduled, jobs); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			names := jobNames(t, r)
+			if names.Has(job.Name) != test.kept {
+				t.Errorf("expected previous job kept %v, got jobs %v", test.kept, names.List())
+			}
+			next, _ := copybird.MakeScheduledJob(ctx, scheduled)
+			if names.Has(next.Name) != test.started {
+				t.Errorf("expected scheduled job started %v, got jobs %v", test.started, names.List())
+			}
+			skipped := len(backup.Status.SkippedRuns) != 0
+			if skipped == test.started {
+				t.Errorf("expected run skipped %v, got skipped runs %v", !test.started, backup.Status.SkippedRuns)
+			}
+			events := r.Recorder.(*record.FakeRecorder).Events
+			if skipped && len(events) == 0 {
+				t.Errorf("no event for the skipped run")
+			}
+		})
+	}
+}
+
+func TestDeleteFinishedJobs(t *testing.T) {
+	int32Ptr := func(v int32) *int32 { return &v }
+	now := time.Now()
+	job := func(name string, age time.Duration, condition v1.JobConditionType) *v1.Job {
+		job := &v1.Job{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "db",
+			CreationTimestamp: metav1.NewTime(now.Add(-age)),
+		}}
+		if condition != "" {
+			job.Status.Conditions = []v1.JobCondition{{Type: condition, Status: corev1.ConditionTrue}}
+		}
+		return job
+	}
+	jobs := []*v1.Job{
+		job("succeeded-1", 4*time.Hour, v1.JobComplete),
+		job("succeeded-2", 3*time.Hour, v1.JobComplete),
+		job("succeeded-3", 2*time.Hour, v1.JobComplete),
+		job("failed-1", 5*time.Hour, v1.JobFailed),
+		job("failed-2", time.Hour, v1.JobFailed),
+		job("running", 6*time.Hour, ""),
+	}
+
+	tests := map[string]struct {
+		successful, failed *int32
+		expected           []string
+	}{
+		"default limits": {
+			expected: []string{"failed-2", "running", "succeeded-1", "succeeded-2", "succeeded-3"},
+		},
+		"limits": {
+			successful: int32Ptr(2),
+			failed:     int32Ptr(0),
+			expected:   []string{"running", "succeeded-2", "succeeded-3"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			backup := scheduledBackup()
+			backup.Spec.SuccessfulJobsHistoryLimit = test.successful
+			backup.Spec.FailedJobsHistoryLimit = test.failed
+			var objects []runtime.Object
+			var list []v1.Job
+			for _, job := range jobs {
+				objects = append(objects, job.DeepCopy())
+				list = append(list, *job.DeepCopy())
+			}
+			r := newTestBackupReconciler(t, objects...)
+
+			if err := deleteFinishedJobs(context.Background(), r.Client, backup, list); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if names := jobNames(t, r).List(); !equality.Semantic.DeepEqual(names, test.expected) {
+				t.Errorf("expected jobs %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestLastScheduleTime(t *testing.T) {
+	main := metav1.NewTime(time.Now().Add(-time.Hour))
+	hourly := metav1.NewTime(time.Now().Add(-time.Minute))
+	backup := scheduledBackup()
+	backup.Status.LastScheduleTime = &main
+	backup.Status.Schedules = []backupv1alpha1.ScheduleStatus{{Name: "hourly", LastScheduleTime: &hourly}}
+
+	tests := map[string]struct {
+		schedule string
+		expected *metav1.Time
+	}{
+		"main schedule":    {expected: &main},
+		"named schedule":   {schedule: "hourly", expected: &hourly},
+		"unknown schedule": {schedule: "daily"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			last := lastScheduleTime(backup, test.schedule)
+			if !equality.Semantic.DeepEqual(last, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, last)
+			}
+		})
+	}
+}
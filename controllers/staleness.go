@@ -0,0 +1,70 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// reconcileStaleness sets the Stale condition of the Backup comparing the
+// latest successful backup with its maxAge, whether the CronJob has fired
+// or not. It returns the time the fresh Backup goes stale at, so it is
+// checked again then.
+func (r *BackupReconciler) reconcileStaleness(backup *backupv1alpha1.Backup, now time.Time) (time.Time, bool) {
+	if backup.Spec.MaxAge == nil {
+		if backupv1alpha1.FindCondition(backup.Status.Conditions, backupv1alpha1.ConditionStale) != nil {
+			setBackupCondition(backup, backupv1alpha1.ConditionStale, corev1.ConditionFalse, "MaxAgeNotSet", "")
+		}
+		return time.Time{}, false
+	}
+	maxAge := backup.Spec.MaxAge.Duration
+
+	// Backup that has never succeeded is stale once it is older than maxAge
+	since := backup.CreationTimestamp
+	message := fmt.Sprintf("no successful backup since creation, maxAge is %s", maxAge)
+	if last := lastSuccessfulRun(&backup.Status); last != nil {
+		since = *last
+		message = fmt.Sprintf("latest successful backup finished at %s, maxAge is %s", last.UTC().Format(time.RFC3339), maxAge)
+	}
+
+	staleAt := since.Add(maxAge)
+	if now.Before(staleAt) {
+		setBackupCondition(backup, backupv1alpha1.ConditionStale, corev1.ConditionFalse, "WithinMaxAge", message)
+		return staleAt, true
+	}
+	if !backupv1alpha1.IsConditionTrue(backup.Status.Conditions, backupv1alpha1.ConditionStale) {
+		r.Recorder.Event(backup, corev1.EventTypeWarning, "Stale", message)
+	}
+	setBackupCondition(backup, backupv1alpha1.ConditionStale, corev1.ConditionTrue, "MaxAgeExceeded", message)
+	return time.Time{}, false
+}
+
+// lastSuccessfulRun returns the finish time of the latest successful run
+func lastSuccessfulRun(status *backupv1alpha1.BackupStatus) *metav1.Time {
+	last := status.LastSuccessfulTime
+	for _, job := range status.Jobs {
+		if job.Success && job.FinishTime != nil && (last == nil || last.Before(job.FinishTime)) {
+			last = job.FinishTime
+		}
+	}
+	return last
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	backupv1alpha1 "github.com/copybird/copybird-crd/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestReconcileStaleness(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	ago := func(d time.Duration) *metav1.Time {
+		t := metav1.NewTime(now.Add(-d))
+		return &t
+	}
+
+	tests := map[string]struct {
+		mutate func(backup *backupv1alpha1.Backup)
+		// status and reason of the Stale condition, none if it's empty
+		status  corev1.ConditionStatus
+		reason  string
+		staleAt time.Time
+	}{
+		"no maxAge": {
+			mutate: func(backup *backupv1alpha1.Backup) { backup.Spec.MaxAge = nil },
+		},
+		"maxAge removed": {
+			mutate: func(backup *backupv1alpha1.Backup) {
+				backup.Spec.MaxAge = nil
+				setBackupCondition(backup, backupv1alpha1.ConditionStale, corev1.ConditionTrue, "MaxAgeExceeded", "")
+			},
+			status: corev1.ConditionFalse,
+			reason: "MaxAgeNotSet",
+		},
+		"within maxAge": {
+			mutate: func(backup *backupv1alpha1.Backup) {
+				backup.Status.LastSuccessfulTime = ago(time.Hour)
+			},
+			status:  corev1.ConditionFalse,
+			reason:  "WithinMaxAge",
+			staleAt: now.Add(time.Hour),
+		},
+		"successful job newer than the last successful time": {
+			mutate: func(backup *backupv1alpha1.Backup) {
+				backup.Status.LastSuccessfulTime = ago(5 * time.Hour)
+				backup.Status.Jobs = []backupv1alpha1.JobStatus{{Name: "job", Success: true, FinishTime: ago(time.Hour)}}
+			},
+			status:  corev1.ConditionFalse,
+			reason:  "WithinMaxAge",
+			staleAt: now.Add(time.Hour),
+		},
+		"maxAge exceeded": {
+			mutate: func(backup *backupv1alpha1.Backup) {
+				backup.Status.LastSuccessfulTime = ago(5 * time.Hour)
+				backup.Status.Jobs = []backupv1alpha1.JobStatus{{Name: "job", FinishTime: ago(time.Hour)}}
+			},
+			status: corev1.ConditionTrue,
+			reason: "MaxAgeExceeded",
+		},
+		"never succeeded since recent creation": {
+			mutate: func(backup *backupv1alpha1.Backup) {
+				backup.CreationTimestamp = *ago(time.Hour)
+			},
+			status:  corev1.ConditionFalse,
+			reason:  "WithinMaxAge",
+			staleAt: now.Add(time.Hour),
+		},
+		"never succeeded": {
+			mutate: func(backup *backupv1alpha1.Backup) {
+				backup.CreationTimestamp = *ago(3 * time.Hour)
+			},
+			status: corev1.ConditionTrue,
+			reason: "MaxAgeExceeded",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			backup := &backupv1alpha1.Backup{
+				ObjectMeta: metav1.ObjectMeta{Name: "mysql-backup", Namespace: "db"},
+				Spec: backupv1alpha1.BackupSpec{
+					Schedule: "0 * * * *",
+					MaxAge:   &metav1.Duration{Duration: 2 * time.Hour},
+				},
+			}
+			test.mutate(backup)
+			r := newTestBackupReconciler(t)
+
+			// the stale Backup is reported once
+			r.reconcileStaleness(backup, now)
+			staleAt, ok := r.reconcileStaleness(backup, now)
+
+			if ok != !test.staleAt.IsZero() || !staleAt.Equal(test.staleAt) {
+				t.Errorf("expected stale at %v, got %v (%v)", test.staleAt, staleAt, ok)
+			}
+			condition := backupv1alpha1.FindCondition(backup.Status.Conditions, backupv1alpha1.ConditionStale)
+			if test.status == "" {
+				if condition != nil {
+					t.Errorf("unexpected condition: %+v", condition)
+				}
+			} else if condition == nil || condition.Status != test.status || condition.Reason != test.reason {
+				t.Errorf("expected condition %s/%s, got %+v", test.status, test.reason, condition)
+			}
+			events := len(r.Recorder.(*record.FakeRecorder).Events)
+			if stale := test.status == corev1.ConditionTrue; (events == 1) != stale || events > 1 {
+				t.Errorf("expected stale event %v, got %d events", stale, events)
+			}
+		})
+	}
+}
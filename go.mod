@@ -1,6 +1,6 @@
 module github.com/copybird/copybird-crd
 
-go 1.12
+go 1.15
 
 require (
 	github.com/copybird/copybird v0.0.0-20190723053855-e1eb5118b1cb
@@ -11,6 +11,8 @@ require (
 	github.com/onsi/ginkgo v1.8.0
 	github.com/onsi/gomega v1.5.0
 	github.com/pierrec/lz4 v2.0.5+incompatible
+	github.com/prometheus/client_golang v1.0.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/stretchr/testify v1.3.0
 	golang.org/x/sync v0.0.0-20190423024810-112230192c58
 	gotest.tools v2.2.0+incompatible
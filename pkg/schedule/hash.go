@@ -0,0 +1,110 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// hashToken is the schedule field value replaced by a hashed one
+const hashToken = "H"
+
+// hashBounds are the bounds hashed values are picked from for each field.
+// Days of the month are limited to 28, so the backup runs every month.
+var hashBounds = []bounds{minutes, hours, {1, 28, nil}, months, dow}
+
+// Hash replaces Jenkins-style H tokens in the schedule with values derived
+// from the key, so schedules sharing the same H tokens are spread out
+// while the schedule of a single key stays the same:
+//
+//	H          a value in the field range
+//	H(1-4)     a value in the range
+//	H/15       every 15 units starting at a value below 15
+//	H(0-29)/10 every 10 units within the range starting at a hashed value
+func Hash(spec, key string) (string, error) {
+	if !strings.Contains(spec, hashToken) {
+		return spec, nil
+	}
+	f := strings.Fields(spec)
+	if len(f) != 5 {
+		return "", fmt.Errorf("expected 5 fields in schedule %q, found %d", spec, len(f))
+	}
+	for i := range f {
+		parts := strings.Split(f[i], ",")
+		for j, part := range parts {
+			if !strings.HasPrefix(part, hashToken) {
+				continue
+			}
+			hashed, err := hashPart(part, hashBounds[i], hashValue(key, i))
+			if err != nil {
+				return "", err
+			}
+			parts[j] = hashed
+		}
+		f[i] = strings.Join(parts, ",")
+	}
+	return strings.Join(f, " "), nil
+}
+
+// hashPart renders a single H token of a field
+func hashPart(part string, b bounds, hash uint32) (string, error) {
+	rangeAndStep := strings.SplitN(strings.TrimPrefix(part, hashToken), "/", 2)
+	low, high := b.min, b.max
+
+	if r := rangeAndStep[0]; r != "" {
+		if !strings.HasPrefix(r, "(") || !strings.HasSuffix(r, ")") {
+			return "", fmt.Errorf("invalid hashed value %q", part)
+		}
+		lowAndHigh := strings.SplitN(strings.Trim(r, "()"), "-", 2)
+		if len(lowAndHigh) != 2 {
+			return "", fmt.Errorf("invalid hashed range %q", part)
+		}
+		var err error
+		if low, err = value(lowAndHigh[0], b); err != nil {
+			return "", err
+		}
+		if high, err = value(lowAndHigh[1], b); err != nil {
+			return "", err
+		}
+		if low > high {
+			return "", fmt.Errorf("invalid hashed range %q", part)
+		}
+	}
+
+	if len(rangeAndStep) == 1 {
+		return strconv.Itoa(low + int(hash%uint32(high-low+1))), nil
+	}
+	step, err := strconv.Atoi(rangeAndStep[1])
+	if err != nil || step <= 0 {
+		return "", fmt.Errorf("invalid step in %q", part)
+	}
+	start := low + int(hash%uint32(step))
+	if start > high {
+		start = low
+	}
+	return fmt.Sprintf("%d-%d/%d", start, high, step), nil
+}
+
+// hashValue returns a hash of the key, different for each schedule field
+func hashValue(key string, field int) uint32 {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s/%d", key, field)
+	return h.Sum32()
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	spreads := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("default/backup-%d", i)
+		hashed, err := Hash("H H(1-4) * * *", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := Hash("H H(1-4) * * *", key); again != hashed {
+			t.Errorf("%s: expected stable schedule %q, got %q", key, hashed, again)
+		}
+		var minute, hour int
+		if _, err := fmt.Sscanf(hashed, "%d %d * * *", &minute, &hour); err != nil {
+			t.Fatalf("%s: unexpected schedule %q", key, hashed)
+		}
+		if minute < 0 || minute > 59 || hour < 1 || hour > 4 {
+			t.Errorf("%s: schedule %q is out of range", key, hashed)
+		}
+		spreads[hashed] = true
+	}
+	if len(spreads) < 10 {
+		t.Errorf("expected schedules to be spread, got %d distinct ones", len(spreads))
+	}
+
+	tests := map[string]string{
+		"0 2 * * *":          "0 2 * * *",
+		"H/15 * * * *":       "-59/15 * * * *",
+		"H(0-29)/10 * * * *": "-29/10 * * * *",
+	}
+	for spec, suffix := range tests {
+		hashed, err := Hash(spec, "default/backup")
+		if err != nil {
+			t.Errorf("%q: %v", spec, err)
+		} else if len(hashed) < len(suffix) || hashed[len(hashed)-len(suffix):] != suffix {
+			t.Errorf("%q: expected schedule ending with %q, got %q", spec, suffix, hashed)
+		}
+	}
+
+	for _, spec := range []string{"H(4-1) * * * *", "H(1) * * * *", "H/0 * * * *", "Hx * * * *"} {
+		if hashed, err := Hash(spec, "default/backup"); err == nil {
+			t.Errorf("%q: expected error, got %q", spec, hashed)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package schedule renders Backup schedules to the ones run by CronJobs
+package schedule
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// cron field bounds
+type bounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minutes = bounds{0, 59, nil}
+	hours   = bounds{0, 23, nil}
+	dom     = bounds{1, 31, nil}
+	months  = bounds{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dow = bounds{0, 6, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// descriptors are predefined schedules expanded to standard ones
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// fields splits a standard cron schedule into its five fields
+func fields(spec string) ([]string, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = expanded
+	}
+	f := strings.Fields(spec)
+	if len(f) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in schedule %q, found %d", spec, len(f))
+	}
+	return f, nil
+}
+
+// isWildcard returns true if the field matches any value
+func isWildcard(field string) bool {
+	return field == "*" || field == "?"
+}
+
+// expand returns sorted values matched by the cron field
+func expand(field string, b bounds) ([]int, error) {
+	matched := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		rangeAndStep := strings.SplitN(part, "/", 2)
+		low, high := b.min, b.max
+		step := 1
+
+		if r := rangeAndStep[0]; !isWildcard(r) {
+			lowAndHigh := strings.SplitN(r, "-", 2)
+			var err error
+			if low, err = value(lowAndHigh[0], b); err != nil {
+				return nil, err
+			}
+			high = low
+			if len(lowAndHigh) == 2 {
+				if high, err = value(lowAndHigh[1], b); err != nil {
+					return nil, err
+				}
+			} else if len(rangeAndStep) == 2 {
+				// "n/step" means from n to the end of the range
+				high = b.max
+			}
+		}
+		if len(rangeAndStep) == 2 {
+			var err error
+			if step, err = strconv.Atoi(rangeAndStep[1]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		if low > high {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+		for v := low; v <= high; v += step {
+			matched[v] = true
+		}
+	}
+
+	return keys(matched), nil
+}
+
+// keys returns sorted values of the set
+func keys(set map[int]bool) []int {
+	var values []int
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Ints(values)
+	return values
+}
+
+// value parses a single field value, either a number or a name
+func value(s string, b bounds) (int, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < b.min || v > b.max {
+		return 0, fmt.Errorf("value %d is out of range %d-%d", v, b.min, b.max)
+	}
+	return v, nil
+}
+
+// format renders sorted values as a cron field, joining consecutive
+// values into ranges
+func format(values []int, b bounds) string {
+	if len(values) == b.max-b.min+1 {
+		return "*"
+	}
+	var parts []string
+	for i := 0; i < len(values); {
+		j := i
+		for j+1 < len(values) && values[j+1] == values[j]+1 {
+			j++
+		}
+		if j == i {
+			parts = append(parts, strconv.Itoa(values[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", values[i], values[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+// ToUTC translates the schedule in the location to the UTC schedule firing
+// at the same times while the location has the UTC offset it has at the given
+// time. CronJobs run schedules in the kube-controller-manager time zone,
+// which is expected to be UTC. An error is returned if the translated
+// schedule can't be expressed as a single cron schedule, e.g. when shifting
+// runs to the previous day would change a day of the month.
+func ToUTC(spec string, loc *time.Location, at time.Time) (string, error) {
+	if strings.HasPrefix(spec, "@every") {
+		// intervals don't depend on the time zone
+		return spec, nil
+	}
+	f, err := fields(spec)
+	if err != nil {
+		return "", err
+	}
+	_, offset := at.In(loc).Zone()
+	if offset == 0 {
+		return strings.Join(f, " "), nil
+	}
+	if offset%60 != 0 {
+		return "", fmt.Errorf("UTC offset of %s is not a whole number of minutes", loc)
+	}
+
+	localMinutes, err := expand(f[0], minutes)
+	if err != nil {
+		return "", err
+	}
+	localHours, err := expand(f[1], hours)
+	if err != nil {
+		return "", err
+	}
+
+	// shift every run time by the offset, tracking the day it moves to
+	utcMinutes, utcHours := map[int]bool{}, map[int]bool{}
+	runs, dayShifts := map[int]bool{}, map[int]bool{}
+	for _, h := range localHours {
+		for _, m := range localMinutes {
+			t := h*60 + m - offset/60
+			dayShift := floorDiv(t, minutesPerDay)
+			t -= dayShift * minutesPerDay
+			utcHours[t/60] = true
+			utcMinutes[t%60] = true
+			runs[t] = true
+			dayShifts[dayShift] = true
+		}
+	}
+	if len(runs) != len(utcHours)*len(utcMinutes) {
+		return "", fmt.Errorf("schedule %q can't be expressed in UTC with offset %s", spec, formatOffset(offset))
+	}
+
+	dayOfMonth, month, dayOfWeek := f[2], f[3], f[4]
+	everyDay := isWildcard(dayOfMonth) && isWildcard(month) && isWildcard(dayOfWeek)
+	if !everyDay {
+		if len(dayShifts) != 1 {
+			return "", fmt.Errorf("schedule %q runs on different days in UTC with offset %s", spec, formatOffset(offset))
+		}
+		if dayShift := keys(dayShifts)[0]; dayShift != 0 {
+			// runs moved to another day can be expressed only by shifting
+			// days of the week, as months have different lengths
+			if !isWildcard(dayOfMonth) || !isWildcard(month) {
+				return "", fmt.Errorf("schedule %q moves to another day of the month in UTC with offset %s", spec, formatOffset(offset))
+			}
+			days, err := expand(dayOfWeek, dow)
+			if err != nil {
+				return "", err
+			}
+			for i := range days {
+				days[i] = (days[i] + dayShift + 7) % 7
+			}
+			sort.Ints(days)
+			dayOfWeek = format(days, dow)
+		}
+	}
+
+	return strings.Join([]string{
+		format(keys(utcMinutes), minutes),
+		format(keys(utcHours), hours),
+		dayOfMonth, month, dayOfWeek,
+	}, " "), nil
+}
+
+// NextTransition returns the first time after t the location changes its
+// UTC offset, e.g. switches to or from daylight saving time. False is
+// returned if the offset doesn't change within a year.
+func NextTransition(loc *time.Location, t time.Time) (time.Time, bool) {
+	_, offset := t.In(loc).Zone()
+	changed := func(t time.Time) bool {
+		_, o := t.In(loc).Zone()
+		return o != offset
+	}
+
+	// find the day of the transition, then bisect it down to a second
+	low := t
+	for i := 0; i < 366; i++ {
+		high := low.Add(24 * time.Hour)
+		if !changed(high) {
+			low = high
+			continue
+		}
+		for high.Sub(low) > time.Second {
+			middle := low.Add(high.Sub(low) / 2)
+			if changed(middle) {
+				high = middle
+			} else {
+				low = middle
+			}
+		}
+		return high.Truncate(time.Second), true
+	}
+	return time.Time{}, false
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+func formatOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, offset/3600, offset%3600/60)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestToUTC(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone database is not available:", err)
+	}
+	kolkata := time.FixedZone("IST", 5*3600+30*60)
+	winter := time.Date(2019, time.January, 15, 0, 0, 0, 0, time.UTC)
+	summer := time.Date(2019, time.July, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec     string
+		loc      *time.Location
+		at       time.Time
+		expected string
+		fails    bool
+	}{
+		{spec: "0 2 * * *", loc: berlin, at: winter, expected: "0 1 * * *"},
+		{spec: "0 2 * * *", loc: berlin, at: summer, expected: "0 0 * * *"},
+		{spec: "@daily", loc: berlin, at: winter, expected: "0 23 * * *"},
+		{spec: "30 0 * * mon-fri", loc: berlin, at: winter, expected: "30 23 * * 0-4"},
+		{spec: "0 */6 * * *", loc: berlin, at: winter, expected: "0 5,11,17,23 * * *"},
+		{spec: "0 3 * * *", loc: kolkata, at: winter, expected: "30 21 * * *"},
+		{spec: "@every 1h", loc: berlin, at: winter, expected: "@every 1h"},
+		{spec: "0 0 1 * *", loc: berlin, at: winter, fails: true},
+		{spec: "0,45 3 * * *", loc: kolkata, at: winter, fails: true},
+	}
+	for _, test := range tests {
+		actual, err := ToUTC(test.spec, test.loc, test.at)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%q in %s: expected error, got %q", test.spec, test.loc, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q in %s: %v", test.spec, test.loc, err)
+		} else if actual != test.expected {
+			t.Errorf("%q in %s: expected %q, got %q", test.spec, test.loc, test.expected, actual)
+		}
+	}
+}
+
+func TestNextTransition(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone database is not available:", err)
+	}
+	next, ok := NextTransition(berlin, time.Date(2019, time.January, 15, 0, 0, 0, 0, time.UTC))
+	expected := time.Date(2019, time.March, 31, 1, 0, 0, 0, time.UTC)
+	if !ok || !next.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, next)
+	}
+	if _, ok := NextTransition(time.UTC, time.Now()); ok {
+		t.Error("expected no transitions in UTC")
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// maxIterations limits walking over schedule times, so windows opening
+// more often than their duration don't loop forever
+const maxIterations = 1000
+
+// ActiveWindow returns start and end of the window opened on the schedule
+// for the duration that is open at t. Windows overlapping it are merged
+// into it. False is returned if no window is open at t.
+func ActiveWindow(start cron.Schedule, duration time.Duration, t time.Time) (time.Time, time.Time, bool) {
+	// the earliest window open at t is the first one started
+	// after its duration before t
+	begin := start.Next(t.Add(-duration))
+	if begin.IsZero() || begin.After(t) {
+		return time.Time{}, time.Time{}, false
+	}
+	end := begin.Add(duration)
+	next := start.Next(begin)
+	for i := 0; i < maxIterations && !next.IsZero() && next.Before(end); i++ {
+		end = next.Add(duration)
+		next = start.Next(next)
+	}
+	return begin, end, true
+}
+
+// Runs returns times the schedule fires at within [from, to)
+func Runs(schedule cron.Schedule, from, to time.Time) []time.Time {
+	var runs []time.Time
+	// schedules have a second precision
+	next := schedule.Next(from.Add(-time.Second))
+	for i := 0; i < maxIterations && !next.IsZero() && next.Before(to); i++ {
+		runs = append(runs, next)
+		next = schedule.Next(next)
+	}
+	return runs
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2019 Mad Devs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestActiveWindow(t *testing.T) {
+	monthEnd, _ := cron.ParseStandard("0 0 28 * *")
+	date := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2019, month, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	begin, end, ok := ActiveWindow(monthEnd, 96*time.Hour, date(time.March, 30, 12))
+	if !ok || !begin.Equal(date(time.March, 28, 0)) || !end.Equal(date(time.April, 1, 0)) {
+		t.Errorf("unexpected window %s - %s, open: %t", begin, end, ok)
+	}
+	if _, _, ok := ActiveWindow(monthEnd, 96*time.Hour, date(time.April, 1, 0)); ok {
+		t.Error("window must be closed at its end")
+	}
+
+	// overlapping windows are merged
+	mondayAndTuesday, _ := cron.ParseStandard("0 0 * * mon,tue")
+	begin, end, ok = ActiveWindow(mondayAndTuesday, 36*time.Hour, date(time.March, 4, 6))
+	if !ok || !begin.Equal(date(time.March, 4, 0)) || !end.Equal(date(time.March, 6, 12)) {
+		t.Errorf("unexpected merged window %s - %s, open: %t", begin, end, ok)
+	}
+
+	daily, _ := cron.ParseStandard("0 0 * * *")
+	runs := Runs(daily, date(time.March, 28, 0), date(time.April, 1, 0))
+	if len(runs) != 4 || !runs[0].Equal(date(time.March, 28, 0)) {
+		t.Errorf("unexpected runs %v", runs)
+	}
+}